@@ -0,0 +1,173 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/simon-watiau/hass-run/hass"
+	"github.com/simon-watiau/hass-run/pid"
+	"github.com/simon-watiau/hass-run/runner"
+	"github.com/spf13/cast"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var cleanupCmd = &cobra.Command{
+	Use:          "cleanup [flags] [PIDFile]...",
+	Short:        "Remove stale PID files and reset their entities",
+	Long:         `Remove the PID files left behind by runs that did not exit cleanly, the daemon being killed or the host rebooted for instance, and set their entities to the stale state. Without arguments, the PID files of the configured jobs are checked.`,
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		err := viper.ReadInConfig()
+
+		if err != nil && len(args) == 0 {
+			return err
+		}
+
+		return cleanup(args)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(cleanupCmd)
+
+	cleanupCmd.Flags().StringP("host", "f", "", "HomeAssistant host (e.g https://hass.fr)")
+	cleanupCmd.Flags().StringP("bearer", "b", "", "Bearer token for HomeAssistant")
+	cleanupCmd.Flags().Duration("request-timeout", hass.DefaultTimeout, "Timeout of a single HomeAssistant request (0 for no timeout)")
+	cleanupCmd.Flags().Int("retries", 5, "Maximum number of attempts of a HomeAssistant request")
+}
+
+func cleanup(pidFiles []string) error {
+	// entities and transports of the PID files, when known from the
+	// configuration
+	entities := map[string]string{}
+	transports := map[string]string{}
+
+	if len(pidFiles) == 0 {
+		jobs, err := loadJobs()
+
+		if err != nil {
+			return fmt.Errorf("invalid configuration: %w", err)
+		}
+
+		for _, job := range jobs {
+			pidFiles = append(pidFiles, job.pidFile)
+			entities[job.pidFile] = job.entity
+
+			if transport, ok := job.settings["transport"]; ok {
+				transports[job.pidFile] = cast.ToString(transport)
+			}
+		}
+
+		sort.Strings(pidFiles)
+	}
+
+	failed := false
+
+	for _, pidFile := range pidFiles {
+		transport, ok := transports[pidFile]
+
+		if !ok {
+			transport = viper.GetString("transport")
+		}
+
+		result, err := cleanupPIDFile(pidFile, entities[pidFile], transport)
+
+		if err != nil {
+			failed = true
+			fmt.Printf("%s: %s\n", pidFile, err.Error())
+			continue
+		}
+
+		fmt.Printf("%s: %s\n", pidFile, result)
+	}
+
+	if failed {
+		return &exitError{code: 1}
+	}
+
+	return nil
+}
+
+// cleanupPIDFile removes pidFile if its run is gone and resets the entity of
+// the run through transport, returning what was done.
+func cleanupPIDFile(pidFile string, entity string, transport string) (string, error) {
+	stateFile := runner.NewStateFile(stateFilePath(pidFile))
+
+	if _, err := os.Stat(pidFile); err != nil {
+		payload, err := stateFile.Load()
+
+		if err != nil || payload.State != runner.StateRunning {
+			return "clean", nil
+		}
+	}
+
+	// the files are removed while holding the lock, so that a run starting
+	// meanwhile is either seen running or starts once they are gone
+	lock, err := pid.Acquire(pidFile, false)
+
+	if errors.Is(err, pid.ErrLocked) {
+		return "running", nil
+	}
+
+	if err != nil {
+		return "", fmt.Errorf("failed to lock PID file: %w", err)
+	}
+
+	payload, stateErr := stateFile.Load()
+
+	if identity, err := pid.ReadIdentity(pidFile); err == nil && entity == "" {
+		entity = identity.Entity
+	}
+
+	payload.State = runner.StateStale
+	payload.Attributes.Running = false
+
+	content, err := json.Marshal(payload)
+
+	if err != nil {
+		lock.Release()
+		return "", fmt.Errorf("failed to marshal payload: %w", err)
+	}
+
+	if stateErr == nil && entity != "" {
+		err = stateFile.UpdateState(string(content))
+
+		if err != nil {
+			lock.Release()
+			return "", err
+		}
+	}
+
+	err = os.Remove(pid.IdentityPath(pidFile))
+
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		lock.Release()
+		return "", fmt.Errorf("failed to remove stale file: %w", err)
+	}
+
+	// releasing the lock removes the PID file
+	err = lock.Release()
+
+	if err != nil {
+		return "", fmt.Errorf("failed to remove stale file: %w", err)
+	}
+
+	if entity == "" {
+		return "removed stale PID file, its entity is unknown", nil
+	}
+
+	client, closeClient := newTransportPublisher(transport, entity)
+	defer closeClient()
+
+	err = client.UpdateState(string(content))
+
+	if err != nil {
+		return "", fmt.Errorf("removed stale PID file but failed to reset %s: %w", entity, err)
+	}
+
+	return fmt.Sprintf("removed stale PID file, %s set to %s", entity, runner.StateStale), nil
+}
@@ -0,0 +1,204 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/simon-watiau/hass-run/pid"
+	"github.com/simon-watiau/hass-run/runner"
+	"github.com/spf13/viper"
+)
+
+// Concurrency policies, applied when a job is started while it is running.
+const (
+	concurrencyReject  = "reject"
+	concurrencyQueue   = "queue"
+	concurrencyReplace = "replace"
+)
+
+// killWait is how long to wait for a killed run to exit.
+const killWait = 5 * time.Second
+
+// holderWriteDelay is how long to wait for a run that locked its PID file to
+// write its PID.
+const holderWriteDelay = time.Second
+
+func validateConcurrency(policy string) error {
+	switch policy {
+	case concurrencyReject, concurrencyQueue, concurrencyReplace:
+		return nil
+	}
+
+	return fmt.Errorf(
+		"invalid concurrency policy %q (expected %s, %s or %s)",
+		policy,
+		concurrencyReject,
+		concurrencyQueue,
+		concurrencyReplace,
+	)
+}
+
+// applyConcurrency rejects or replaces the run of entity holding pidFile, if
+// any, a queued run waiting for it once daemonized instead.
+func applyConcurrency(entity string, pidFile string) error {
+	policy := viper.GetString("concurrency")
+
+	if policy == concurrencyQueue {
+		return nil
+	}
+
+	holder, err := verifiedHolder(pidFile, entity, nil)
+
+	if err != nil {
+		return err
+	}
+
+	if holder == 0 {
+		return nil
+	}
+
+	if policy == concurrencyReplace {
+		log.Printf("Replacing running command (PID %d)", holder)
+
+		process, err := os.FindProcess(holder)
+
+		if err != nil {
+			return fmt.Errorf("failed to replace running command: %w", err)
+		}
+
+		return stopProcess(process, pidFile, viper.GetDuration("replace-wait"))
+	}
+
+	err = syscall.Kill(holder, runner.RejectSignal)
+
+	if err != nil {
+		log.Printf("Failed to notify running command: %s", err.Error())
+	}
+
+	return &exitError{
+		code: 1,
+		err:  fmt.Errorf("command rejected, it is already running (PID %d)", holder),
+	}
+}
+
+// runningHolder returns the PID of the run holding pidFile, 0 if there is
+// none, giving a run that just started some time to write its PID.
+func runningHolder(pidFile string) (int, error) {
+	deadline := time.Now().Add(holderWriteDelay)
+
+	for {
+		holder, err := pid.Holder(pidFile)
+
+		if !errors.Is(err, pid.ErrLocked) || time.Now().After(deadline) {
+			if err != nil {
+				return 0, fmt.Errorf("failed to check running command: %w", err)
+			}
+
+			return holder, nil
+		}
+
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// verifiedHolder returns the PID of the run holding pidFile, 0 if there is
+// none, after checking that it runs entity and, if not nil, argv.
+func verifiedHolder(pidFile string, entity string, argv []string) (int, error) {
+	holder, err := runningHolder(pidFile)
+
+	if err != nil || holder == 0 {
+		return holder, err
+	}
+
+	// the run records its identity right after writing its PID
+	deadline := time.Now().Add(holderWriteDelay)
+
+	for {
+		identity, err := pid.ReadIdentity(pidFile)
+
+		if err == nil && identity.PID == holder {
+			err = identity.Verify(holder, entity, argv)
+
+			if err != nil {
+				return 0, fmt.Errorf("refusing to signal PID %d: %w", holder, err)
+			}
+
+			return holder, nil
+		}
+
+		if time.Now().After(deadline) {
+			return 0, fmt.Errorf("refusing to signal PID %d: no matching identity in %s", holder, pid.IdentityPath(pidFile))
+		}
+
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// stopProcess cancels the run of process holding pidFile, killing its whole
+// session if it did not stop within wait.
+func stopProcess(process *os.Process, pidFile string, wait time.Duration) error {
+	err := process.Signal(runner.CancelSignal)
+
+	if err != nil {
+		return fmt.Errorf("failed to kill running command: %w", err)
+	}
+
+	if stopped(process.Pid, pidFile, wait) {
+		return nil
+	}
+
+	log.Printf("Command did not stop in time, killing it")
+
+	// the daemon is a session leader, killing its session takes down the
+	// whole process tree of the command. A run in the foreground is not, its
+	// command running in a process group of its own.
+	err = pid.KillChildGroups(process.Pid)
+
+	if err == nil {
+		err = pid.KillSession(process.Pid)
+	}
+
+	if err == nil {
+		err = syscall.Kill(process.Pid, syscall.SIGKILL)
+	}
+
+	if err != nil && err != syscall.ESRCH {
+		return fmt.Errorf("failed to kill running command: %w", err)
+	}
+
+	if !stopped(process.Pid, pidFile, killWait) {
+		return fmt.Errorf("failed to kill running command: PID %d is still running", process.Pid)
+	}
+
+	return nil
+}
+
+// stopped reports whether the run holder released pidFile within wait, which
+// it does once its command ended, or exited.
+func stopped(holder int, pidFile string, wait time.Duration) bool {
+	deadline := time.Now().Add(wait)
+
+	for {
+		if !pid.Alive(holder) {
+			return true
+		}
+
+		// the run keeps retrying to deliver its final state after
+		// releasing the lock, or a new run already took it
+		current, err := pid.Holder(pidFile)
+
+		if errors.Is(err, pid.ErrLocked) || (err == nil && current != holder) {
+			return true
+		}
+
+		if time.Now().After(deadline) {
+			return false
+		}
+
+		time.Sleep(100 * time.Millisecond)
+	}
+}
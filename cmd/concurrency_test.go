@@ -0,0 +1,252 @@
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/simon-watiau/hass-run/pid"
+	"github.com/simon-watiau/hass-run/runner"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/suite"
+)
+
+// holderEnv makes the test binary hold the PID file it names instead of
+// running the tests, standing for a running command.
+const holderEnv = "HASS_RUN_TEST_HOLDER"
+
+// stubbornEnv makes the holder ignore cancellations.
+const stubbornEnv = "HASS_RUN_TEST_STUBBORN"
+
+// lingeringEnv makes the holder keep running once cancelled, like a run
+// retrying to deliver its final state.
+const lingeringEnv = "HASS_RUN_TEST_LINGERING"
+
+const holderEntity = "shell.backup"
+
+func TestMain(m *testing.M) {
+	if path := os.Getenv(holderEnv); path != "" {
+		holdPIDFile(path)
+		return
+	}
+
+	os.Exit(m.Run())
+}
+
+// holdPIDFile locks path like a run in the foreground, its command running
+// in a process group of its own, and prints the signals it receives.
+func holdPIDFile(path string) {
+	lock, err := pid.Acquire(path, false)
+
+	if err == nil {
+		err = lock.WritePid()
+	}
+
+	var identity pid.Identity
+
+	if err == nil {
+		identity, err = pid.CurrentIdentity(holderEntity, nil)
+	}
+
+	if err == nil {
+		err = pid.WriteIdentity(path, identity)
+	}
+
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, runner.CancelSignal, runner.RejectSignal)
+
+	command := exec.Command("sleep", "60")
+	command.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	err = command.Start()
+
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+
+	fmt.Printf("ready %d\n", command.Process.Pid)
+
+	for sig := range signals {
+		fmt.Println(sig.String())
+
+		if sig == runner.CancelSignal && os.Getenv(stubbornEnv) == "" {
+			command.Process.Kill()
+			lock.Release()
+
+			if os.Getenv(lingeringEnv) != "" {
+				continue
+			}
+
+			os.Exit(0)
+		}
+	}
+}
+
+type ConcurrencyTestSuite struct {
+	suite.Suite
+	pidFile string
+	holder  *exec.Cmd
+	output  *bufio.Reader
+	command int
+}
+
+func (suite *ConcurrencyTestSuite) SetupTest() {
+	viper.Reset()
+	viper.Set("replace-wait", 200*time.Millisecond)
+
+	suite.pidFile = filepath.Join(suite.T().TempDir(), "backup.pid")
+	suite.holder = nil
+}
+
+func (suite *ConcurrencyTestSuite) TearDownTest() {
+	if suite.holder != nil {
+		syscall.Kill(suite.command, syscall.SIGKILL)
+		suite.holder.Process.Kill()
+		suite.holder.Wait()
+	}
+}
+
+// startHolder starts a process holding the PID file, configured by the
+// given environment variables.
+func (suite *ConcurrencyTestSuite) startHolder(env ...string) {
+	suite.holder = exec.Command(os.Args[0])
+	suite.holder.Env = append(os.Environ(), holderEnv+"="+suite.pidFile)
+
+	for _, name := range env {
+		suite.holder.Env = append(suite.holder.Env, name+"=1")
+	}
+
+	stdout, err := suite.holder.StdoutPipe()
+	suite.Require().Nil(err)
+	suite.Require().Nil(suite.holder.Start())
+
+	suite.output = bufio.NewReader(stdout)
+
+	_, err = fmt.Sscanf(suite.readLine(), "ready %d", &suite.command)
+	suite.Require().Nil(err)
+}
+
+func (suite *ConcurrencyTestSuite) readLine() string {
+	line, err := suite.output.ReadString('\n')
+	suite.Require().Nil(err)
+
+	return strings.TrimSpace(line)
+}
+
+func (suite *ConcurrencyTestSuite) TestNoRunningCommand() {
+	for _, policy := range []string{concurrencyReject, concurrencyQueue, concurrencyReplace} {
+		viper.Set("concurrency", policy)
+
+		suite.Nil(applyConcurrency(holderEntity, suite.pidFile), policy)
+	}
+}
+
+func (suite *ConcurrencyTestSuite) TestReject() {
+	suite.startHolder()
+	viper.Set("concurrency", concurrencyReject)
+
+	err := applyConcurrency(holderEntity, suite.pidFile)
+
+	var exitErr *exitError
+	suite.True(errors.As(err, &exitErr))
+	suite.Equal(1, exitErr.code)
+	suite.ErrorContains(err, "already running")
+
+	// the running command is notified of the rejection
+	suite.Equal(runner.RejectSignal.String(), suite.readLine())
+	suite.True(pid.Alive(suite.holder.Process.Pid))
+}
+
+func (suite *ConcurrencyTestSuite) TestQueue() {
+	suite.startHolder()
+	viper.Set("concurrency", concurrencyQueue)
+
+	suite.Nil(applyConcurrency(holderEntity, suite.pidFile))
+	suite.True(pid.Alive(suite.holder.Process.Pid))
+}
+
+func (suite *ConcurrencyTestSuite) TestReplace() {
+	suite.startHolder()
+	viper.Set("concurrency", concurrencyReplace)
+
+	suite.Nil(applyConcurrency(holderEntity, suite.pidFile))
+	suite.Equal(runner.CancelSignal.String(), suite.readLine())
+	suite.Eventually(func() bool { return !pid.Alive(suite.holder.Process.Pid) }, time.Second, 10*time.Millisecond)
+}
+
+func (suite *ConcurrencyTestSuite) TestReplaceKillsStubbornCommand() {
+	suite.startHolder(stubbornEnv)
+	viper.Set("concurrency", concurrencyReplace)
+
+	suite.Nil(applyConcurrency(holderEntity, suite.pidFile))
+	suite.Eventually(func() bool { return !pid.Alive(suite.holder.Process.Pid) }, time.Second, 10*time.Millisecond)
+	suite.Eventually(func() bool { return !pid.Alive(suite.command) }, time.Second, 10*time.Millisecond)
+}
+
+func (suite *ConcurrencyTestSuite) TestReplaceLingeringCommand() {
+	suite.startHolder(lingeringEnv)
+	viper.Set("concurrency", concurrencyReplace)
+	viper.Set("replace-wait", time.Minute)
+
+	start := time.Now()
+
+	// the run released its PID file, there is no need to wait for it
+	suite.Nil(applyConcurrency(holderEntity, suite.pidFile))
+	suite.Less(time.Since(start), 10*time.Second)
+	suite.Equal(runner.CancelSignal.String(), suite.readLine())
+	suite.True(pid.Alive(suite.holder.Process.Pid))
+}
+
+func (suite *ConcurrencyTestSuite) TestRefuseOtherEntity() {
+	suite.startHolder()
+	viper.Set("concurrency", concurrencyReplace)
+
+	suite.ErrorIs(applyConcurrency("shell.other", suite.pidFile), pid.ErrIdentityMismatch)
+	suite.True(pid.Alive(suite.holder.Process.Pid))
+}
+
+func (suite *ConcurrencyTestSuite) TestCleanupKeepsRunningCommand() {
+	suite.startHolder()
+
+	result, err := cleanupPIDFile(suite.pidFile, "", "")
+
+	suite.Nil(err)
+	suite.Equal("running", result)
+	suite.FileExists(suite.pidFile)
+	suite.FileExists(pid.IdentityPath(suite.pidFile))
+}
+
+func (suite *ConcurrencyTestSuite) TestCleanupStalePIDFile() {
+	suite.Require().Nil(ioutil.WriteFile(suite.pidFile, []byte("1234"), 0644))
+
+	result, err := cleanupPIDFile(suite.pidFile, "", "")
+
+	suite.Nil(err)
+	suite.Equal("removed stale PID file, its entity is unknown", result)
+	suite.NoFileExists(suite.pidFile)
+
+	result, err = cleanupPIDFile(suite.pidFile, "", "")
+
+	suite.Nil(err)
+	suite.Equal("clean", result)
+	suite.NoFileExists(suite.pidFile)
+}
+
+func TestConcurrencyTestSuite(t *testing.T) {
+	suite.Run(t, new(ConcurrencyTestSuite))
+}
@@ -0,0 +1,54 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// defaultStateDir returns the directory where hass-run keeps name by
+// default, private to the user following the XDG base directory
+// specification.
+func defaultStateDir(name string) string {
+	if dir := os.Getenv("XDG_STATE_HOME"); dir != "" {
+		return filepath.Join(dir, "hass-run", name)
+	}
+
+	if home, err := os.UserHomeDir(); err == nil {
+		return filepath.Join(home, ".local", "state", "hass-run", name)
+	}
+
+	return filepath.Join(os.TempDir(), fmt.Sprintf("hass-run-%d", os.Getuid()), name)
+}
+
+// ensurePrivateDir creates dir if needed and checks that it is a directory,
+// not a symbolic link, owned by the current user and that other users cannot
+// write to, so that they cannot plant links to the files written in it.
+func ensurePrivateDir(dir string) error {
+	err := os.MkdirAll(dir, 0700)
+
+	if err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	info, err := os.Lstat(dir)
+
+	if err != nil {
+		return fmt.Errorf("failed to check directory: %w", err)
+	}
+
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok && int(stat.Uid) != os.Getuid() {
+		return fmt.Errorf("%s is owned by another user", dir)
+	}
+
+	if info.Mode().Perm()&0022 != 0 {
+		return fmt.Errorf("%s is writable by other users", dir)
+	}
+
+	return nil
+}
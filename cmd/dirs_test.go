@@ -0,0 +1,56 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type DirsTestSuite struct {
+	suite.Suite
+	dir string
+}
+
+func (suite *DirsTestSuite) SetupTest() {
+	suite.dir = suite.T().TempDir()
+}
+
+func (suite *DirsTestSuite) TestDefaultStateDir() {
+	suite.T().Setenv("XDG_STATE_HOME", suite.dir)
+
+	suite.Equal(filepath.Join(suite.dir, "hass-run", "logs"), defaultStateDir("logs"))
+}
+
+func (suite *DirsTestSuite) TestEnsurePrivateDir() {
+	dir := filepath.Join(suite.dir, "hass-run", "spool")
+
+	suite.Nil(ensurePrivateDir(dir))
+
+	info, err := os.Stat(dir)
+	suite.Nil(err)
+	suite.Equal(os.FileMode(0700), info.Mode().Perm())
+
+	// existing directories are accepted as well
+	suite.Nil(ensurePrivateDir(dir))
+}
+
+func (suite *DirsTestSuite) TestRejectSharedDir() {
+	dir := filepath.Join(suite.dir, "shared")
+	suite.Nil(os.Mkdir(dir, 0777))
+	suite.Nil(os.Chmod(dir, 0777))
+
+	suite.ErrorContains(ensurePrivateDir(dir), "writable by other users")
+}
+
+func (suite *DirsTestSuite) TestRejectSymlink() {
+	link := filepath.Join(suite.dir, "link")
+	suite.Nil(os.Symlink(suite.T().TempDir(), link))
+
+	suite.ErrorContains(ensurePrivateDir(link), "not a directory")
+}
+
+func TestDirsTestSuite(t *testing.T) {
+	suite.Run(t, new(DirsTestSuite))
+}
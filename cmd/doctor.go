@@ -0,0 +1,205 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/simon-watiau/hass-run/hass"
+	"github.com/simon-watiau/hass-run/runner"
+	"github.com/spf13/cast"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var doctorCmd = &cobra.Command{
+	Use:          "doctor",
+	Short:        "Check the configuration",
+	Args:         cobra.NoArgs,
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return doctor(cmd)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(doctorCmd)
+
+	doctorCmd.Flags().StringP("host", "f", "", "HomeAssistant host (e.g https://hass.fr)")
+	doctorCmd.Flags().StringP("bearer", "b", "", "Bearer token for HomeAssistant")
+}
+
+// doctorReport prints the result of each check, remembering failures.
+type doctorReport struct {
+	failed bool
+}
+
+func (r *doctorReport) check(name string, err error, detail string) {
+	if err != nil {
+		r.failed = true
+		fmt.Printf("[FAIL] %s: %s\n", name, err.Error())
+		return
+	}
+
+	if detail == "" {
+		fmt.Printf("[ OK ] %s\n", name)
+		return
+	}
+
+	fmt.Printf("[ OK ] %s: %s\n", name, detail)
+}
+
+func doctor(cmd *cobra.Command) error {
+	report := &doctorReport{}
+
+	err := viper.ReadInConfig()
+
+	if err != nil {
+		report.check("config file", err, "")
+	} else {
+		report.check("config file", nil, viper.ConfigFileUsed())
+	}
+
+	report.check("settings", validateRootSettings(), "")
+
+	report.check(
+		"host/bearer",
+		hass.ValidateHostAndBearer(viper.GetString("host"), viper.GetString("bearer")),
+		viper.GetString("host"),
+	)
+
+	names := []string{}
+
+	for name := range viper.GetStringMap("jobs") {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		job, err := parseJob(name, viper.Sub("jobs."+name))
+
+		if err == nil {
+			err = checkJobCommand(job)
+		}
+
+		if err != nil {
+			report.check("job "+name, err, "")
+			continue
+		}
+
+		report.check(
+			"job "+name,
+			nil,
+			fmt.Sprintf("%s, %s, %s", job.entity, job.pidFile, strings.Join(job.command, " ")),
+		)
+	}
+
+	if report.failed {
+		return &exitError{code: 1}
+	}
+
+	return nil
+}
+
+// validateRootSettings checks the settings of the configuration file applying
+// to every run, the way run checks them. Settings of other commands are
+// ignored.
+func validateRootSettings() error {
+	keys := map[string]bool{}
+
+	// the nested keys of hooks are listed too
+	for _, key := range viper.AllKeys() {
+		keys[strings.SplitN(key, ".", 2)[0]] = true
+	}
+
+	names := make([]string, 0, len(keys))
+
+	for key := range keys {
+		names = append(names, key)
+	}
+
+	sort.Strings(names)
+
+	for _, key := range names {
+		if _, ok := hookSettings[key]; ok {
+			_, err := parseHooks(key, viper.Get(key))
+
+			if err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		if jobFlags.Lookup(key) == nil || jobExcludedFlags[key] {
+			continue
+		}
+
+		err := validateJobSetting(key, viper.Get(key))
+
+		if err != nil {
+			return err
+		}
+	}
+
+	if viper.GetString("transport") == transportMQTT && viper.GetString("mqtt-broker") == "" {
+		return errors.New("the mqtt transport requires mqtt-broker")
+	}
+
+	return nil
+}
+
+// checkJobCommand checks that the working directory, the environment file and
+// the binary of a job exist.
+func checkJobCommand(job jobConfig) error {
+	cwd := cast.ToString(job.settings["cwd"])
+
+	if cwd != "" {
+		info, err := os.Stat(cwd)
+
+		if err != nil {
+			return fmt.Errorf("invalid cwd: %w", err)
+		}
+
+		if !info.IsDir() {
+			return fmt.Errorf("invalid cwd: %s is not a directory", cwd)
+		}
+	}
+
+	if envFile := cast.ToString(job.settings["env-file"]); envFile != "" {
+		_, err := runner.ReadEnvFile(envFile)
+
+		if err != nil {
+			return fmt.Errorf("invalid env-file: %w", err)
+		}
+	}
+
+	_, err := runner.LookupCredential(
+		cast.ToString(job.settings["user"]),
+		cast.ToString(job.settings["group"]),
+	)
+
+	if err != nil {
+		return fmt.Errorf("invalid user/group: %w", err)
+	}
+
+	bin := job.command[0]
+
+	// relative paths are resolved from the working directory of the command
+	if strings.Contains(bin, "/") && !filepath.IsAbs(bin) && cwd != "" {
+		bin = filepath.Join(cwd, bin)
+	}
+
+	_, err = exec.LookPath(bin)
+
+	if err != nil {
+		return fmt.Errorf("invalid command: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,146 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/simon-watiau/hass-run/hass"
+	"github.com/simon-watiau/hass-run/runner"
+	"github.com/spf13/viper"
+)
+
+func newHass(entity string) *hass.Hass {
+	return hass.NewHass(
+		viper.GetString("bearer"),
+		viper.GetString("host"),
+		entity,
+		hass.WithTimeout(viper.GetDuration("request-timeout")),
+		hass.WithRetryPolicy(hass.RetryPolicy{
+			MaxAttempts:    viper.GetInt("retries"),
+			InitialBackoff: time.Second,
+			MaxBackoff:     30 * time.Second,
+		}),
+	)
+}
+
+// Transports of the entity updates.
+const (
+	transportREST      = "rest"
+	transportWebSocket = "websocket"
+	transportMQTT      = "mqtt"
+)
+
+func validateTransport(transport string) error {
+	switch transport {
+	case transportREST, transportWebSocket, transportMQTT:
+		return nil
+	}
+
+	return fmt.Errorf(
+		"invalid transport %q (expected %s, %s or %s)",
+		transport,
+		transportREST,
+		transportWebSocket,
+		transportMQTT,
+	)
+}
+
+// newPublisher returns the client publishing the states of entity, through
+// MQTT when it is the configured transport, and the function closing it.
+// options only apply to MQTT.
+func newPublisher(entity string, options ...hass.MQTTOption) (runner.Hass, func()) {
+	return newTransportPublisher(viper.GetString("transport"), entity, options...)
+}
+
+// newTransportPublisher is newPublisher for the given transport.
+func newTransportPublisher(transport string, entity string, options ...hass.MQTTOption) (runner.Hass, func()) {
+	if transport != transportMQTT {
+		return newHass(entity), func() {}
+	}
+
+	options = append(
+		[]hass.MQTTOption{
+			hass.WithCredentials(viper.GetString("mqtt-username"), viper.GetString("mqtt-password")),
+			hass.WithDiscoveryPrefix(viper.GetString("mqtt-discovery-prefix")),
+			hass.WithMQTTTimeout(viper.GetDuration("request-timeout")),
+		},
+		options...,
+	)
+
+	client := hass.NewMQTT(viper.GetString("mqtt-broker"), entity, options...)
+
+	err := client.Connect()
+
+	if err != nil {
+		log.Printf("Failed to connect to the MQTT broker: %s", err.Error())
+	}
+
+	return client, func() { client.Close() }
+}
+
+// newWebSocket connects to the WebSocket API, the connection being retried in
+// the background when it fails.
+func newWebSocket(entity string) *hass.WebSocket {
+	ws := hass.NewWebSocket(newHass(entity))
+
+	err := ws.Connect()
+
+	if err != nil {
+		log.Printf("Failed to connect to the WebSocket API: %s", err.Error())
+	}
+
+	return ws
+}
+
+// clearSpooled drops the final state left over by the previous run of
+// entity, since the new run is about to replace it.
+func clearSpooled(entity string) {
+	err := hass.NewSpool(viper.GetString("spool-dir"), entity).Clear()
+
+	if err != nil {
+		log.Printf("Failed to clear spooled state of %s: %s", entity, err.Error())
+	}
+}
+
+// deliverSpooled sends the final states left over by previous runs of other
+// entities than current.
+func deliverSpooled(current string) {
+	dir := viper.GetString("spool-dir")
+
+	entities, err := hass.SpooledEntities(dir)
+
+	if err != nil {
+		log.Printf("Failed to list spooled states: %s", err.Error())
+		return
+	}
+
+	for _, entity := range entities {
+		if entity == current {
+			continue
+		}
+
+		spool := hass.NewSpool(dir, entity)
+		json, err := spool.Load()
+
+		if err != nil {
+			log.Printf("Failed to load spooled state of %s: %s", entity, err.Error())
+			continue
+		}
+
+		client, closeClient := newPublisher(entity)
+		err = client.UpdateState(json)
+		closeClient()
+
+		if err != nil {
+			log.Printf("Failed to deliver spooled state of %s: %s", entity, err.Error())
+			continue
+		}
+
+		err = spool.Clear()
+
+		if err != nil {
+			log.Printf("Failed to clear spooled state of %s: %s", entity, err.Error())
+		}
+	}
+}
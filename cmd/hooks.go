@@ -0,0 +1,105 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/simon-watiau/hass-run/runner"
+	"github.com/spf13/cast"
+	"github.com/spf13/viper"
+)
+
+// hookSettings call services once a run succeeded or failed. They are set,
+// at the root of the configuration file or in a job, to a service call or a
+// list of them:
+//
+//	on-failure:
+//	  service: notify.mobile_app_phone
+//	  output-lines: 5
+//	  data:
+//	    title: Backup failed
+//	    message: "{{.Entity}} exited with {{.ExitCode}}: {{.Output}}"
+var hookSettings = map[string]string{
+	"on-failure": runner.HookOnFailure,
+	"on-success": runner.HookOnSuccess,
+}
+
+func parseHooks(key string, value interface{}) ([]*runner.Hook, error) {
+	var calls []interface{}
+
+	switch value := value.(type) {
+	case nil:
+		return nil, nil
+	case []interface{}:
+		calls = value
+	default:
+		calls = []interface{}{value}
+	}
+
+	hooks := []*runner.Hook{}
+
+	for _, call := range calls {
+		settings, err := cast.ToStringMapE(call)
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: expected a service call or a list of them", key)
+		}
+
+		for name := range settings {
+			if name != "service" && name != "data" && name != "output-lines" {
+				return nil, fmt.Errorf("invalid %s: unknown setting %s", key, name)
+			}
+		}
+
+		data := map[string]interface{}{}
+
+		if settings["data"] != nil {
+			data, err = cast.ToStringMapE(settings["data"])
+
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s: data must be a map", key)
+			}
+		}
+
+		outputLines := runner.DefaultHookOutputLines
+
+		if settings["output-lines"] != nil {
+			outputLines, err = cast.ToIntE(settings["output-lines"])
+
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s: invalid output-lines: %w", key, err)
+			}
+
+			if outputLines < 0 {
+				return nil, fmt.Errorf("invalid %s: invalid output-lines: must be positive", key)
+			}
+		}
+
+		hook, err := runner.NewHook(hookSettings[key], cast.ToString(settings["service"]), data, outputLines)
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", key, err)
+		}
+
+		hooks = append(hooks, hook)
+	}
+
+	return hooks, nil
+}
+
+// configuredHooks returns the hooks of the run, set by the configuration file
+// or its job.
+func configuredHooks() ([]*runner.Hook, error) {
+	hooks := []*runner.Hook{}
+
+	for _, key := range []string{"on-success", "on-failure"} {
+		parsed, err := parseHooks(key, viper.Get(key))
+
+		if err != nil {
+			return nil, err
+		}
+
+		hooks = append(hooks, parsed...)
+	}
+
+	return hooks, nil
+}
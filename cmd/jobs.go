@@ -0,0 +1,276 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/simon-watiau/hass-run/hass"
+	"github.com/simon-watiau/hass-run/pid"
+	"github.com/simon-watiau/hass-run/runner"
+	"github.com/spf13/cast"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// jobConfig is a job of the jobs section of the configuration file:
+//
+//	jobs:
+//	  backup:
+//	    entity: shell.backup
+//	    pid-file: /tmp/backup.pid
+//	    command: restic backup /home
+//	    timeout: 2h
+//
+// The command is either a list of arguments or a string run by sh, the other
+// settings being named after the flags of the run command, or hooks.
+type jobConfig struct {
+	name     string
+	entity   string
+	pidFile  string
+	command  []string
+	settings map[string]interface{}
+}
+
+// jobFlags are the flags of the run command, set once it is initialized.
+var jobFlags *pflag.FlagSet
+
+// jobExcludedFlags are the flags of the run command that cannot be set by a
+// job.
+var jobExcludedFlags = map[string]bool{
+	"host":          true,
+	"bearer":        true,
+	"nodaemon":      true,
+	"job":           true,
+	"mqtt-broker":   true,
+	"mqtt-username": true,
+	"mqtt-password": true,
+}
+
+// loadJobs reads and validates every job of the configuration file.
+func loadJobs() (map[string]jobConfig, error) {
+	jobs := map[string]jobConfig{}
+
+	for name := range viper.GetStringMap("jobs") {
+		job, err := parseJob(name, viper.Sub("jobs."+name))
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid job %s: %w", name, err)
+		}
+
+		jobs[name] = job
+	}
+
+	return jobs, nil
+}
+
+func parseJob(name string, config *viper.Viper) (jobConfig, error) {
+	if config == nil {
+		return jobConfig{}, errors.New("expected a map of settings")
+	}
+
+	job := jobConfig{
+		name:     name,
+		entity:   config.GetString("entity"),
+		pidFile:  config.GetString("pid-file"),
+		settings: map[string]interface{}{},
+	}
+
+	err := hass.ValidateEntityName(job.entity)
+
+	if err != nil {
+		return jobConfig{}, err
+	}
+
+	// the PID files of the other jobs may be in use
+	err = pid.ValidatePIDFileLocation(job.pidFile)
+
+	if err != nil {
+		return jobConfig{}, fmt.Errorf("invalid pid-file: %w", err)
+	}
+
+	switch command := config.Get("command").(type) {
+	case string:
+		job.command = []string{"sh", "-c", command}
+	case []interface{}:
+		job.command, err = cast.ToStringSliceE(command)
+	}
+
+	if err != nil || len(job.command) == 0 || job.command[0] == "" {
+		return jobConfig{}, errors.New("command must be a string or a list of arguments")
+	}
+
+	for key := range hookSettings {
+		_, err = parseHooks(key, config.Get(key))
+
+		if err != nil {
+			return jobConfig{}, err
+		}
+
+		if config.IsSet(key) {
+			job.settings[key] = config.Get(key)
+		}
+	}
+
+	for _, key := range config.AllKeys() {
+		if key == "entity" || key == "pid-file" || key == "command" {
+			continue
+		}
+
+		// hooks were handled above, their nested keys are listed too
+		if _, ok := hookSettings[strings.SplitN(key, ".", 2)[0]]; ok {
+			continue
+		}
+
+		err = validateJobSetting(key, config.Get(key))
+
+		if err != nil {
+			return jobConfig{}, err
+		}
+
+		job.settings[key] = config.Get(key)
+	}
+
+	return job, nil
+}
+
+func validateJobSetting(key string, value interface{}) error {
+	flag := jobFlags.Lookup(key)
+
+	if flag == nil || jobExcludedFlags[key] {
+		return fmt.Errorf("unknown setting %s", key)
+	}
+
+	var err error
+
+	switch flag.Value.Type() {
+	case "bool":
+		_, err = cast.ToBoolE(value)
+	case "int":
+		var n int
+		n, err = cast.ToIntE(value)
+
+		if err == nil && key == "event-output-lines" && n < 0 {
+			err = errors.New("must be positive")
+		}
+	case "int64":
+		_, err = cast.ToInt64E(value)
+	case "duration":
+		_, err = cast.ToDurationE(value)
+	case "stringSlice":
+		var events []string
+		events, err = cast.ToStringSliceE(value)
+
+		if err == nil && key == "events" {
+			err = runner.ValidateEvents(events)
+		}
+	case "stringArray":
+		var env []string
+		env, err = cast.ToStringSliceE(value)
+
+		if err == nil && key == "env" {
+			err = runner.ValidateEnv(env)
+		}
+	default:
+		_, err = cast.ToStringE(value)
+	}
+
+	if err != nil {
+		return fmt.Errorf("invalid %s: %w", key, err)
+	}
+
+	switch key {
+	case "output-keep":
+		_, err = runner.ParseRetentionMode(cast.ToString(value))
+	case "kill-signal":
+		_, err = runner.ParseSignal(cast.ToString(value))
+	case "progress":
+		_, err = runner.NewProgressParser(cast.ToString(value))
+	case "concurrency":
+		err = validateConcurrency(cast.ToString(value))
+	case "transport":
+		err = validateTransport(cast.ToString(value))
+	}
+
+	if err != nil {
+		return fmt.Errorf("invalid %s: %w", key, err)
+	}
+
+	return nil
+}
+
+// jobNames lists the configured jobs, for error messages.
+func jobNames(jobs map[string]jobConfig) string {
+	names := make([]string, 0, len(jobs))
+
+	for name := range jobs {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return strings.Join(names, ", ")
+}
+
+// resolveJob returns the arguments of cmd, built from the job given by --job
+// if any. The settings of the job apply to the flags of cmd that were not set
+// on the command line.
+func resolveJob(cmd *cobra.Command, args []string, withCommand bool) ([]string, error) {
+	name, _ := cmd.Flags().GetString("job")
+
+	if name == "" {
+		return args, nil
+	}
+
+	err := viper.ReadInConfig()
+
+	if err != nil {
+		return nil, err
+	}
+
+	jobs, err := loadJobs()
+
+	if err != nil {
+		return nil, err
+	}
+
+	// viper keys are case insensitive
+	job, ok := jobs[strings.ToLower(name)]
+
+	if !ok {
+		return nil, fmt.Errorf("unknown job %q (configured jobs: %s)", name, jobNames(jobs))
+	}
+
+	for key, value := range job.settings {
+		if _, ok := hookSettings[key]; ok {
+			viper.Set(key, value)
+		} else if flag := cmd.Flags().Lookup(key); flag != nil && !flag.Changed {
+			viper.Set(key, value)
+		}
+	}
+
+	args = []string{job.entity, job.pidFile}
+
+	if withCommand {
+		args = append(args, job.command...)
+	}
+
+	return args, nil
+}
+
+// jobArgs accepts either no arguments, with --job, or n arguments.
+func jobArgs(n int, exact bool) cobra.PositionalArgs {
+	return func(cmd *cobra.Command, args []string) error {
+		if name, _ := cmd.Flags().GetString("job"); name != "" {
+			return cobra.NoArgs(cmd, args)
+		}
+
+		if exact {
+			return cobra.ExactArgs(n)(cmd, args)
+		}
+
+		return cobra.MinimumNArgs(n)(cmd, args)
+	}
+}
@@ -0,0 +1,136 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/suite"
+)
+
+type JobsTestSuite struct {
+	suite.Suite
+	dir string
+	cmd *cobra.Command
+}
+
+func (suite *JobsTestSuite) SetupTest() {
+	viper.Reset()
+
+	suite.dir = suite.T().TempDir()
+
+	suite.cmd = &cobra.Command{}
+	suite.cmd.Flags().String("job", "", "")
+	suite.cmd.Flags().Duration("timeout", 0, "")
+}
+
+func (suite *JobsTestSuite) configure(content string) {
+	path := filepath.Join(suite.dir, "hass-run.yaml")
+	suite.Require().Nil(ioutil.WriteFile(path, []byte(content), 0644))
+
+	viper.SetConfigFile(path)
+}
+
+func (suite *JobsTestSuite) resolve(withCommand bool) ([]string, error) {
+	args, err := resolveJob(suite.cmd, nil, withCommand)
+
+	// the flags are bound once the job is resolved, as run does
+	suite.Nil(viper.BindPFlags(suite.cmd.Flags()))
+
+	return args, err
+}
+
+func (suite *JobsTestSuite) TestWithoutJob() {
+	args, err := resolveJob(suite.cmd, []string{"shell.backup", "/tmp/backup.pid"}, false)
+
+	suite.Nil(err)
+	suite.Equal([]string{"shell.backup", "/tmp/backup.pid"}, args)
+}
+
+func (suite *JobsTestSuite) TestResolveJob() {
+	suite.configure(`
+jobs:
+  backup:
+    entity: shell.backup
+    pid-file: ` + suite.dir + `/backup.pid
+    command: restic backup /home
+    timeout: 2h
+    on-failure:
+      service: notify.phone
+`)
+	suite.Nil(suite.cmd.Flags().Set("job", "Backup"))
+
+	args, err := suite.resolve(true)
+
+	suite.Nil(err)
+	suite.Equal([]string{"shell.backup", suite.dir + "/backup.pid", "sh", "-c", "restic backup /home"}, args)
+	suite.Equal(2*time.Hour, viper.GetDuration("timeout"))
+	suite.NotNil(viper.Get("on-failure"))
+}
+
+func (suite *JobsTestSuite) TestCommandLineOverridesJob() {
+	suite.configure(`
+jobs:
+  backup:
+    entity: shell.backup
+    pid-file: ` + suite.dir + `/backup.pid
+    command: [restic, backup, /home]
+    timeout: 2h
+`)
+	suite.Nil(suite.cmd.Flags().Set("job", "backup"))
+	suite.Nil(suite.cmd.Flags().Set("timeout", "1h"))
+
+	args, err := suite.resolve(false)
+
+	suite.Nil(err)
+	suite.Equal([]string{"shell.backup", suite.dir + "/backup.pid"}, args)
+	suite.Equal(time.Hour, viper.GetDuration("timeout"))
+}
+
+func (suite *JobsTestSuite) TestUnknownJob() {
+	suite.configure(`
+jobs:
+  backup:
+    entity: shell.backup
+    pid-file: ` + suite.dir + `/backup.pid
+    command: restic backup /home
+`)
+	suite.Nil(suite.cmd.Flags().Set("job", "restore"))
+
+	_, err := suite.resolve(true)
+
+	suite.ErrorContains(err, `unknown job "restore" (configured jobs: backup)`)
+}
+
+func (suite *JobsTestSuite) TestInvalidJob() {
+	for setting, message := range map[string]string{
+		"timeout: soon":          "invalid timeout",
+		"concurrency: never":     "invalid concurrency",
+		"host: hass.local":       "unknown setting host",
+		"unknown: true":          "unknown setting unknown",
+		"on-success: notify":     "invalid on-success",
+		"event-output-lines: -1": "invalid event-output-lines",
+		"on-failure: {service: notify.phone, output-lines: -1}": "invalid on-failure: invalid output-lines",
+	} {
+		suite.configure(`
+jobs:
+  backup:
+    entity: shell.backup
+    pid-file: ` + suite.dir + `/backup.pid
+    command: restic backup /home
+    ` + setting + `
+`)
+		suite.Nil(suite.cmd.Flags().Set("job", "backup"))
+
+		_, err := suite.resolve(true)
+
+		suite.ErrorContains(err, message, setting)
+	}
+}
+
+func TestJobsTestSuite(t *testing.T) {
+	suite.Run(t, new(JobsTestSuite))
+}
@@ -5,11 +5,11 @@ Copyright © 2022 NAME HERE <EMAIL ADDRESS>
 package cmd
 
 import (
+	"errors"
 	"fmt"
 	"os"
-	"syscall"
+	"time"
 
-	"github.com/sevlyar/go-daemon"
 	"github.com/simon-watiau/hass-run/hass"
 	"github.com/simon-watiau/hass-run/pid"
 	"github.com/spf13/cobra"
@@ -17,12 +17,16 @@ import (
 )
 
 var killCmd = &cobra.Command{
-	Use:        "kill [entity] [PIDFile]",
+	Use:        "kill [flags] ([entity] [PIDFile] | --job [job])",
 	Short:      "Kill a running command",
-	Args:       cobra.ExactArgs(2),
+	Args:       jobArgs(2, true),
 	ArgAliases: []string{"entity", "PIDFile"},
 	RunE: func(cmd *cobra.Command, args []string) error {
-		err := validateKillConfig(cmd, args)
+		args, err := resolveJob(cmd, args, true)
+		if err != nil {
+			return err
+		}
+		err = validateKillConfig(cmd, args)
 		if err != nil {
 			return err
 		}
@@ -40,14 +44,11 @@ func init() {
 
 	killCmd.Flags().StringP("host", "f", "", "HomeAssistant host (e.g https://hass.fr)")
 	killCmd.Flags().StringP("bearer", "b", "", "Bearer token for HomeAssistant")
-
-	viper.BindPFlags(killCmd.Flags())
-
-	viper.SetConfigName("hass-run")
-	viper.SetConfigType("yaml")
-	viper.AddConfigPath(".")
-	viper.AddConfigPath("$HOME")
-	viper.AddConfigPath("/etc")
+	// kill only signals the running command, which reports its state
+	killCmd.Flags().MarkDeprecated("host", "kill does not contact HomeAssistant")
+	killCmd.Flags().MarkDeprecated("bearer", "kill does not contact HomeAssistant")
+	killCmd.Flags().String("job", "", "Kill a job of the configuration file")
+	killCmd.Flags().Duration("wait", 30*time.Second, "Time to wait for the command to stop before killing it")
 }
 
 func validateKillConfig(cmd *cobra.Command, args []string) error {
@@ -57,22 +58,19 @@ func validateKillConfig(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
-	err = hass.ValidateEntityName(args[0])
+	_, err = loadJobs()
 
 	if err != nil {
 		return fmt.Errorf("invalid configuration: %w", err)
 	}
 
-	err = hass.ValidateHostAndBearer(
-		viper.GetString("host"),
-		viper.GetString("bearer"),
-	)
+	err = hass.ValidateEntityName(args[0])
 
 	if err != nil {
-		return fmt.Errorf("invalid host/bearer: %w", err)
+		return fmt.Errorf("invalid configuration: %w", err)
 	}
 
-	err = pid.ValidatePIDFile(
+	err = pid.ValidatePIDFileLocation(
 		args[1],
 	)
 
@@ -84,23 +82,32 @@ func validateKillConfig(cmd *cobra.Command, args []string) error {
 }
 
 func kill(cmd *cobra.Command, args []string) error {
-	context := &daemon.Context{
-		PidFileName: args[1],
-		PidFilePerm: 0644,
-		Args:        os.Args,
+	cmd.SilenceUsage = true
+
+	// the command is only known, and checked, when killing a job
+	holder, err := verifiedHolder(args[1], args[0], argvOrNil(args[2:]))
+
+	if err != nil {
+		return err
+	}
+
+	if holder == 0 {
+		return errors.New("no running command")
 	}
 
-	child, err := context.Search()
+	process, err := os.FindProcess(holder)
 
 	if err != nil {
 		return fmt.Errorf("failed to look for running command: %w", err)
 	}
 
-	err = child.Signal(syscall.SIGTERM)
+	return stopProcess(process, args[1], viper.GetDuration("wait"))
+}
 
-	if err != nil {
-		return fmt.Errorf("failed to kill running command: %w", err)
+func argvOrNil(argv []string) []string {
+	if len(argv) == 0 {
+		return nil
 	}
 
-	return nil
+	return argv
 }
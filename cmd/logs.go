@@ -0,0 +1,261 @@
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/simon-watiau/hass-run/hass"
+	"github.com/simon-watiau/hass-run/pid"
+	"github.com/simon-watiau/hass-run/runner"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const followPollInterval = 250 * time.Millisecond
+
+var logsCmd = &cobra.Command{
+	Use:          "logs [flags] ([entity] [PIDFile] | --job [job])",
+	Short:        "Print the output of a command",
+	Long:         `Print the output of the last runs of a command. When following the output, the PID file tells whether the command is still running: without it, the output is followed until the run records its end.`,
+	Args:         logsArgs,
+	ArgAliases:   []string{"entity", "PIDFile"},
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		err := readLogsConfig()
+		if err != nil {
+			return err
+		}
+		args, err = resolveJob(cmd, args, false)
+		if err != nil {
+			return err
+		}
+		err = validateLogsConfig(cmd, args)
+		if err != nil {
+			return err
+		}
+
+		return logs(cmd, args)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(logsCmd)
+
+	logsCmd.Flags().String("log-dir", defaultStateDir("logs"), "Directory where the output of runs is logged")
+	logsCmd.Flags().BoolP("follow", "f", false, "Keep printing the output until the command ends")
+	logsCmd.Flags().Int("run", 0, "Run to print, 0 being the last one, 1 the one before...")
+	logsCmd.Flags().String("stream", "", "Only print this stream (stdout or stderr)")
+	logsCmd.Flags().String("job", "", "Print the output of a job of the configuration file")
+}
+
+// logsArgs accepts either no arguments, with --job, or an entity and an
+// optional PID file.
+func logsArgs(cmd *cobra.Command, args []string) error {
+	if name, _ := cmd.Flags().GetString("job"); name != "" {
+		return cobra.NoArgs(cmd, args)
+	}
+
+	return cobra.RangeArgs(1, 2)(cmd, args)
+}
+
+// readLogsConfig reads the configuration file, which is optional unless a
+// job is printed.
+func readLogsConfig() error {
+	err := viper.ReadInConfig()
+
+	var notFound viper.ConfigFileNotFoundError
+
+	if errors.As(err, &notFound) {
+		return nil
+	}
+
+	return err
+}
+
+func validateLogsConfig(cmd *cobra.Command, args []string) error {
+	err := hass.ValidateEntityName(args[0])
+
+	if err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	stream := viper.GetString("stream")
+
+	if stream != "" && stream != runner.StreamStdout && stream != runner.StreamStderr {
+		return fmt.Errorf("invalid stream %q (expected %s or %s)", stream, runner.StreamStdout, runner.StreamStderr)
+	}
+
+	if viper.GetInt("run") < 0 {
+		return errors.New("invalid run: must be positive")
+	}
+
+	return nil
+}
+
+func logs(cmd *cobra.Command, args []string) error {
+	path := runner.LogFilePath(viper.GetString("log-dir"), args[0], viper.GetInt("run"))
+
+	file, err := os.Open(path)
+
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+
+	defer file.Close()
+
+	// older runs are over, there is nothing to follow
+	follow := viper.GetBool("follow") && viper.GetInt("run") == 0
+	stream := viper.GetString("stream")
+	reader := bufio.NewReader(file)
+	pending := ""
+	over := false
+	// the followed log may drop its oldest lines, the ones read before are
+	// not printed again
+	var offset int64
+	var last, skipUntil time.Time
+
+	for {
+		line, err := reader.ReadString('\n')
+		pending += line
+		offset += int64(len(line))
+
+		if err == nil {
+			if parsed, err := runner.ParseLogLine(pending); err == nil {
+				if !parsed.Date.After(skipUntil) {
+					pending = ""
+					continue
+				}
+
+				last = parsed.Date
+			}
+
+			ended := printLogLine(pending, stream)
+			pending = ""
+
+			if ended && follow {
+				return nil
+			}
+
+			continue
+		}
+
+		if !errors.Is(err, io.EOF) {
+			return fmt.Errorf("failed to read log file: %w", err)
+		}
+
+		if !follow || over {
+			if pending != "" {
+				printLogLine(pending, stream)
+			}
+
+			return nil
+		}
+
+		rotated, err := logRotated(file, path)
+
+		if err != nil {
+			return err
+		}
+
+		if rotated {
+			// a new run started, the followed one is over
+			return nil
+		}
+
+		shrunk, err := logShrunk(file, offset)
+
+		if err != nil {
+			return err
+		}
+
+		if shrunk {
+			_, err = file.Seek(0, io.SeekStart)
+
+			if err != nil {
+				return fmt.Errorf("failed to read log file: %w", err)
+			}
+
+			reader.Reset(file)
+			pending = ""
+			offset = 0
+			skipUntil = last
+		}
+
+		if len(args) > 1 {
+			holder, err := pid.Holder(args[1])
+
+			// ErrLocked reports a lock whose PID is not written yet
+			if err != nil && !errors.Is(err, pid.ErrLocked) {
+				return fmt.Errorf("failed to check PID file: %w", err)
+			}
+
+			if err == nil && holder == 0 {
+				// the command died without recording its end, what it
+				// wrote until then is printed
+				over = true
+				continue
+			}
+		}
+
+		time.Sleep(followPollInterval)
+	}
+}
+
+// printLogLine prints the text of line if it belongs to stream and reports
+// whether it marks the end of the run.
+func printLogLine(line string, stream string) bool {
+	parsed, err := runner.ParseLogLine(line)
+
+	if err != nil {
+		// not written by hass-run, print it as is
+		fmt.Print(line)
+		return false
+	}
+
+	if parsed.Stream == runner.StreamMeta {
+		return parsed.Ended()
+	}
+
+	if stream == "" || stream == parsed.Stream {
+		fmt.Print(parsed.Text)
+	}
+
+	return false
+}
+
+// logRotated reports whether path no longer is the open file.
+func logRotated(file *os.File, path string) (bool, error) {
+	current, err := os.Stat(path)
+
+	if errors.Is(err, os.ErrNotExist) {
+		return true, nil
+	}
+
+	if err != nil {
+		return false, fmt.Errorf("failed to check log file: %w", err)
+	}
+
+	opened, err := file.Stat()
+
+	if err != nil {
+		return false, fmt.Errorf("failed to check log file: %w", err)
+	}
+
+	return !os.SameFile(current, opened), nil
+}
+
+// logShrunk reports whether the open file is shorter than what was read,
+// its oldest lines having been dropped.
+func logShrunk(file *os.File, offset int64) (bool, error) {
+	info, err := file.Stat()
+
+	if err != nil {
+		return false, fmt.Errorf("failed to check log file: %w", err)
+	}
+
+	return info.Size() < offset, nil
+}
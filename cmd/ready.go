@@ -0,0 +1,200 @@
+package cmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sevlyar/go-daemon"
+	"github.com/simon-watiau/hass-run/runner"
+)
+
+// readyEnv gives the daemon the socket through which it tells the parent
+// process whether the command started.
+const readyEnv = "HASS_RUN_READY"
+
+const readyMessage = "ready"
+
+// readiness is the parent side of the handshake.
+type readiness struct {
+	dir      string
+	listener net.Listener
+}
+
+func listenReadiness() (*readiness, error) {
+	dir, err := ioutil.TempDir("", "hass-run-")
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to create readiness socket: %w", err)
+	}
+
+	listener, err := net.Listen("unix", filepath.Join(dir, "ready.sock"))
+
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, fmt.Errorf("failed to create readiness socket: %w", err)
+	}
+
+	return &readiness{dir: dir, listener: listener}, nil
+}
+
+func (r *readiness) env() string {
+	return readyEnv + "=" + r.listener.Addr().String()
+}
+
+// wait returns the startup error reported by the daemon, child, failing if
+// it exits or does not report anything within timeout.
+func (r *readiness) wait(child *os.Process, timeout time.Duration) error {
+	result := make(chan error, 2)
+
+	go func() {
+		conn, err := r.listener.Accept()
+
+		if err != nil {
+			result <- fmt.Errorf("failed to wait for the daemon: %w", err)
+			return
+		}
+
+		defer conn.Close()
+
+		line, err := bufio.NewReader(conn).ReadString('\n')
+		line = strings.TrimSuffix(line, "\n")
+
+		switch {
+		case err != nil:
+			result <- errors.New("the daemon exited before starting the command")
+		case line == readyMessage:
+			result <- nil
+		default:
+			result <- errors.New(line)
+		}
+	}()
+
+	go func() {
+		state, err := child.Wait()
+
+		if err == nil {
+			// give the result sent just before exiting precedence
+			time.Sleep(100 * time.Millisecond)
+			result <- fmt.Errorf("the daemon exited before starting the command (%s)", state)
+		}
+	}()
+
+	select {
+	case err := <-result:
+		return err
+	case <-time.After(timeout):
+		return fmt.Errorf("the daemon did not start the command within %s", timeout)
+	}
+}
+
+func (r *readiness) close() {
+	r.listener.Close()
+	os.RemoveAll(r.dir)
+}
+
+var (
+	readyOnce sync.Once
+	readyPath string
+)
+
+// notifyReady tells the parent process whether the command started, only the
+// first notification being sent. It does nothing when not daemonized.
+func notifyReady(err error) {
+	readyOnce.Do(func() {
+		if readyPath == "" {
+			return
+		}
+
+		conn, dialErr := net.DialTimeout("unix", readyPath, time.Second)
+
+		if dialErr != nil {
+			log.Printf("Failed to notify startup: %s", dialErr.Error())
+			return
+		}
+
+		defer conn.Close()
+
+		message := readyMessage
+
+		if err != nil {
+			message = strings.ReplaceAll(err.Error(), "\n", " ")
+		}
+
+		_, dialErr = conn.Write([]byte(message + "\n"))
+
+		if dialErr != nil {
+			log.Printf("Failed to notify startup: %s", dialErr.Error())
+		}
+	})
+}
+
+// takeReadiness reads the readiness socket given by the parent process,
+// removing it from the environment passed down to the command.
+func takeReadiness() {
+	readyPath = os.Getenv(readyEnv)
+	os.Unsetenv(readyEnv)
+}
+
+// failDaemon reports an error preventing the daemon from running the
+// command, whose output is discarded, to the parent process and
+// Home-Assistant.
+func failDaemon(args []string, err error) {
+	if !daemon.WasReborn() {
+		return
+	}
+
+	notifyReady(err)
+
+	if len(args) >= 2 {
+		publishFailure(args[0], args[1], err)
+	}
+}
+
+// publishFailure reports a run that failed before its command started.
+func publishFailure(entity string, pidFile string, reason error) {
+	now := time.Now()
+
+	payload := runner.Payload{
+		State: runner.StateFailure,
+		Attributes: runner.Attributes{
+			Output:        reason.Error() + "\n",
+			LastErrorLine: reason.Error(),
+			ExitCode:      runner.CommandFailedExitCode,
+			StartedAt:     now,
+			UpdatedAt:     now,
+			EndedAt:       now,
+		},
+	}
+
+	content, err := json.Marshal(payload)
+
+	if err != nil {
+		log.Printf("Failed to marshal payload: %s", err.Error())
+		return
+	}
+
+	err = runner.NewStateFile(stateFilePath(pidFile)).UpdateState(string(content))
+
+	if err != nil {
+		log.Printf("Failed to write state file: %s", err.Error())
+	}
+
+	client, closeClient := newPublisher(entity)
+	defer closeClient()
+
+	err = client.UpdateState(string(content))
+
+	if err != nil {
+		log.Printf("Failed to publish failure: %s", err.Error())
+	}
+}
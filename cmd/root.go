@@ -1,25 +1,72 @@
 package cmd
 
 import (
+	"errors"
+	"fmt"
 	"os"
 
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
 )
 
 var rootCmd = &cobra.Command{
-	Use:   "hass-run",
-	Short: "Run long commands in homeassistant",
-	Long:  `Execute commands as daemons and update a defined homeassistant entity with the result`,
+	Use:           "hass-run",
+	Short:         "Run long commands in homeassistant",
+	Long:          `Execute commands as daemons and update a defined homeassistant entity with the result`,
+	SilenceErrors: true,
+	// flags are bound once the command is known, as several commands
+	// declare the same flags
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		return viper.BindPFlags(cmd.Flags())
+	},
+}
+
+// exitError makes hass-run exit with a specific code, err being printed if
+// not nil.
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string {
+	if e.err == nil {
+		return fmt.Sprintf("exit code %d", e.code)
+	}
+
+	return e.err.Error()
+}
+
+func (e *exitError) Unwrap() error {
+	return e.err
 }
 
 func Execute() {
 	err := rootCmd.Execute()
 
-	if err != nil {
-		os.Exit(1)
+	if err == nil {
+		return
 	}
+
+	var exitErr *exitError
+
+	if errors.As(err, &exitErr) {
+		if exitErr.err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", exitErr.err)
+		}
+
+		os.Exit(exitErr.code)
+	}
+
+	fmt.Fprintln(os.Stderr, "Error:", err)
+	os.Exit(1)
 }
 
 func init() {
 	rootCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+
+	viper.SetConfigName("hass-run")
+	viper.SetConfigType("yaml")
+	viper.AddConfigPath(".")
+	viper.AddConfigPath("$HOME")
+	viper.AddConfigPath("/etc")
 }
@@ -1,8 +1,15 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
 	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/sevlyar/go-daemon"
 	"github.com/simon-watiau/hass-run/hass"
@@ -13,13 +20,22 @@ import (
 )
 
 var runCmd = &cobra.Command{
-	Use:        "run [flags] [entity] [PIDFile] -- [command]",
+	Use:        "run [flags] ([entity] [PIDFile] -- [command] | --job [job])",
 	Short:      "Run a command",
-	Args:       cobra.MinimumNArgs(3),
+	Args:       jobArgs(3, false),
 	ArgAliases: []string{"entity", "PIDFile", "command"},
 	RunE: func(cmd *cobra.Command, args []string) error {
-		err := validate(cmd, args)
+		if daemon.WasReborn() {
+			takeReadiness()
+		}
+		args, err := resolveJob(cmd, args, true)
+		if err != nil {
+			failDaemon(args, err)
+			return err
+		}
+		err = validate(cmd, args)
 		if err != nil {
+			failDaemon(args, err)
 			return err
 		}
 		err = run(cmd, args)
@@ -36,15 +52,43 @@ func init() {
 
 	runCmd.Flags().StringP("host", "f", "", "HomeAssistant host (e.g https://hass.fr)")
 	runCmd.Flags().StringP("bearer", "b", "", "Bearer token for HomeAssistant")
-	runCmd.Flags().BoolP("nodaemon", "n", false, "Disable daemon for debug")
+	runCmd.Flags().BoolP("nodaemon", "n", false, "Run the command in the foreground, mirroring its output and exit status")
+	runCmd.Flags().String("job", "", "Run a job of the configuration file")
+	runCmd.Flags().String("concurrency", concurrencyReject, "What to do when the command is already running (reject, queue or replace)")
+	runCmd.Flags().Duration("start-timeout", 30*time.Second, "Time to wait for the daemon to start the command")
+	runCmd.Flags().Duration("replace-wait", 30*time.Second, "Time to wait for a replaced command to stop before killing it")
+	runCmd.Flags().String("cwd", "", "Working directory of the command (defaults to the current directory)")
+	runCmd.Flags().StringArray("env", nil, "Environment variable of the command as KEY=VALUE (can be repeated)")
+	runCmd.Flags().String("env-file", "", "File of KEY=VALUE lines setting environment variables of the command")
+	runCmd.Flags().Bool("clear-env", false, "Do not pass the environment of hass-run to the command")
+	runCmd.Flags().String("user", "", "User the command runs as, by name or id")
+	runCmd.Flags().String("group", "", "Group the command runs as, by name or id (defaults to the primary group of --user)")
+	runCmd.Flags().Int("output-max-bytes", 8192, "Maximum size of the output attribute in bytes (0 for unlimited)")
+	runCmd.Flags().Int("output-max-lines", 0, "Maximum number of lines in the output attribute (0 for unlimited)")
+	runCmd.Flags().String("output-keep", string(runner.KeepTail), "Output to keep when truncating (head, tail or head_tail)")
+	runCmd.Flags().Bool("separate-streams", false, "Also publish stdout and stderr in their own attributes")
+	runCmd.Flags().Bool("structured-output", false, "Publish the output lines tagged with their stream and date")
+	runCmd.Flags().String("progress", "", "Extract the progress from the output, with a preset (percent or rsync) or a regular expression")
+	runCmd.Flags().Duration("publish-interval", time.Second, "Minimum interval between two entity updates")
+	runCmd.Flags().StringSlice("events", nil, "Events fired on the event bus of HomeAssistant (started, finished, failed and/or output)")
+	runCmd.Flags().Int("event-output-lines", runner.DefaultEventOutputLines, "Number of output lines included in the finished and failed events")
+	runCmd.Flags().String("transport", transportREST, "How to talk to HomeAssistant: rest, websocket (events and hooks through the WebSocket API, states through the REST API) or mqtt")
+	runCmd.Flags().String("mqtt-broker", "", "MQTT broker of the mqtt transport (e.g tcp://localhost:1883)")
+	runCmd.Flags().String("mqtt-username", "", "Username for the MQTT broker")
+	runCmd.Flags().String("mqtt-password", "", "Password for the MQTT broker")
+	runCmd.Flags().String("mqtt-discovery-prefix", hass.DefaultDiscoveryPrefix, "Discovery prefix of HomeAssistant")
+	runCmd.Flags().Duration("request-timeout", hass.DefaultTimeout, "Timeout of a single HomeAssistant request (0 for no timeout)")
+	runCmd.Flags().Int("retries", 5, "Maximum number of attempts of a HomeAssistant request")
+	runCmd.Flags().String("spool-dir", defaultStateDir("spool"), "Directory where undelivered final states are kept")
+	runCmd.Flags().Duration("linger", 24*time.Hour, "How long the daemon keeps retrying to deliver the final state")
+	runCmd.Flags().String("log-dir", defaultStateDir("logs"), "Directory where the output of runs is logged (empty to disable)")
+	runCmd.Flags().Int64("log-max-size", 10*1024*1024, "Maximum size in bytes of the log of a run (0 for unlimited)")
+	runCmd.Flags().Int("log-max-files", 5, "Number of run logs kept per entity")
+	runCmd.Flags().Duration("timeout", 0, "Terminate the command after this duration (0 for no timeout)")
+	runCmd.Flags().String("kill-signal", "SIGTERM", "Signal sent to terminate the command")
+	runCmd.Flags().Duration("grace-period", runner.DefaultGracePeriod, "Delay before killing a command that ignores the kill signal")
 
-	viper.BindPFlags(runCmd.Flags())
-
-	viper.SetConfigName("hass-run")
-	viper.SetConfigType("yaml")
-	viper.AddConfigPath(".")
-	viper.AddConfigPath("$HOME")
-	viper.AddConfigPath("/etc")
+	jobFlags = runCmd.Flags()
 }
 
 func validate(cmd *cobra.Command, args []string) error {
@@ -54,22 +98,86 @@ func validate(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
+	_, err = loadJobs()
+
+	if err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+
 	err = hass.ValidateEntityName(args[0])
 
 	if err != nil {
 		return fmt.Errorf("invalid configuration: %w", err)
 	}
 
-	err = hass.ValidateHostAndBearer(
-		viper.GetString("host"),
-		viper.GetString("bearer"),
-	)
+	err = validateTransport(viper.GetString("transport"))
+
+	if err != nil {
+		return err
+	}
+
+	err = runner.ValidateEvents(viper.GetStringSlice("events"))
+
+	if err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	if viper.GetInt("event-output-lines") < 0 {
+		return errors.New("invalid event-output-lines: must be positive")
+	}
+
+	hooks, err := configuredHooks()
+
+	if err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	if viper.GetString("transport") == transportMQTT && viper.GetString("mqtt-broker") == "" {
+		return errors.New("invalid configuration: the mqtt transport requires --mqtt-broker")
+	}
+
+	// the daemon does not check again what the parent process checked
+	if !daemon.WasReborn() {
+		err = validateEnvironment(args, len(hooks) > 0)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	err = validateConcurrency(viper.GetString("concurrency"))
 
 	if err != nil {
-		return fmt.Errorf("invalid host/bearer: %w", err)
+		return err
+	}
+
+	_, err = commandOptions()
+
+	if err != nil {
+		return err
 	}
 
-	err = pid.ValidatePIDFile(
+	_, err = runnerOptions(args[0], args[1])
+
+	return err
+}
+
+// validateEnvironment checks the host, bearer, PID file and directories of a
+// run.
+func validateEnvironment(args []string, withHooks bool) error {
+	// events and hooks go through the REST or WebSocket API, even with MQTT
+	if viper.GetString("transport") != transportMQTT || len(viper.GetStringSlice("events")) > 0 || withHooks {
+		err := hass.ValidateHostAndBearer(
+			viper.GetString("host"),
+			viper.GetString("bearer"),
+		)
+
+		if err != nil {
+			return fmt.Errorf("invalid host/bearer: %w", err)
+		}
+	}
+
+	err := pid.ValidatePIDFileLocation(
 		args[1],
 	)
 
@@ -77,49 +185,403 @@ func validate(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("invalid PID file: %w", err)
 	}
 
+	err = ensurePrivateDir(viper.GetString("spool-dir"))
+
+	if err != nil {
+		return fmt.Errorf("invalid spool-dir: %w", err)
+	}
+
 	return nil
 }
 
 func run(cmd *cobra.Command, args []string) error {
+	// the arguments are valid, usage does not help with further errors
+	cmd.SilenceUsage = true
+
+	// rejected runs notify this one, which must not terminate it before the
+	// runner handles the notification, even once daemonized
+	signal.Ignore(runner.RejectSignal)
+
+	if !daemon.WasReborn() {
+		err := applyConcurrency(args[0], args[1])
+
+		if err != nil {
+			return err
+		}
+	}
+
+	queued := viper.GetString("concurrency") == concurrencyQueue
+
 	context := &daemon.Context{
-		PidFileName: args[1],
 		PidFilePerm: 0644,
 	}
 
+	// the PID file is locked before daemonizing so that concurrent runs are
+	// rejected synchronously, queued runs waiting for it once daemonized
+	if !queued {
+		context.PidFileName = args[1]
+	}
+
+	release := func() {}
+
 	if !viper.GetBool("nodaemon") {
-		child, err := context.Reborn()
+		if !daemon.WasReborn() {
+			return spawn(context)
+		}
+
+		_, err := context.Reborn()
+
+		if err != nil {
+			err = fmt.Errorf("failed to initialize daemon: %w", err)
+			failDaemon(args, err)
+			return err
+		}
+
+		release = func() { context.Release() }
+
+		// the command must not inherit the lock of the PID file, which it
+		// would keep once the daemon died
+		for _, fd := range daemonFiles(context) {
+			syscall.CloseOnExec(fd)
+		}
+	}
+
+	err := runCommand(args, queued, release)
+
+	var exitErr *exitError
+
+	// exit errors report runs that were rejected or failed, whose state
+	// was already published
+	if err != nil && !errors.As(err, &exitErr) {
+		notifyReady(err)
+		publishFailure(args[0], args[1], err)
+	}
+
+	return err
+}
+
+// daemonFiles returns the descriptors go-daemon passes to the daemon started
+// from context: a copy of /dev/null, followed by the locked PID file when
+// context has one.
+func daemonFiles(context *daemon.Context) []int {
+	files := []int{3}
+
+	if context.PidFileName != "" {
+		files = append(files, 4)
+	}
+
+	return files
+}
+
+// spawn starts the daemon and waits for it to start the command, returning
+// the error that prevented it from doing so.
+func spawn(context *daemon.Context) error {
+	ready, err := listenReadiness()
+
+	if err != nil {
+		return err
+	}
+
+	defer ready.close()
+
+	context.Env = append(os.Environ(), ready.env())
+
+	child, err := context.Reborn()
+
+	if err != nil {
+		return fmt.Errorf("failed to spawn daemon: %w", err)
+	}
+
+	err = ready.wait(child, viper.GetDuration("start-timeout"))
+
+	if err != nil {
+		return &exitError{code: 1, err: fmt.Errorf("failed to start command: %w", err)}
+	}
+
+	log.Printf("Child process started")
+
+	return nil
+}
+
+// runCommand runs the command once daemonized, returning the errors that
+// prevented it from starting. release unlocks the PID file locked while
+// daemonizing.
+func runCommand(args []string, queued bool, release func()) error {
+	// the PID file is unlocked before lingering, so that the next runs are
+	// not rejected while the final state is retried
+	releases := []func(){release}
+	var unlockOnce sync.Once
+
+	unlock := func() {
+		unlockOnce.Do(func() {
+			for i := len(releases) - 1; i >= 0; i-- {
+				releases[i]()
+			}
+		})
+	}
+	defer unlock()
+
+	if queued || viper.GetBool("nodaemon") {
+		if queued {
+			// the command is queued, which is all the parent process
+			// waits for
+			log.Printf("Waiting for the running command to end")
+			notifyReady(nil)
+		}
+
+		lock, err := pid.Acquire(args[1], queued)
+
+		if errors.Is(err, pid.ErrLocked) {
+			return &exitError{code: 1, err: fmt.Errorf("command rejected: %w", err)}
+		}
 
 		if err != nil {
-			return fmt.Errorf("failed to spawn daemon: %w", err)
+			return fmt.Errorf("failed to lock PID file: %w", err)
 		}
 
-		if child != nil {
-			log.Printf("Child process started")
-			// exit parent
-			return nil
+		releases = append(releases, func() { lock.Release() })
+
+		err = lock.WritePid()
+
+		if err != nil {
+			return fmt.Errorf("failed to write PID file: %w", err)
 		}
+	}
+
+	identity, err := pid.CurrentIdentity(args[0], args[2:])
+
+	if err != nil {
+		return err
+	}
+
+	err = pid.WriteIdentity(args[1], identity)
+
+	if err != nil {
+		return fmt.Errorf("failed to write PID file identity: %w", err)
+	}
+
+	releases = append(releases, func() { os.Remove(pid.IdentityPath(args[1])) })
 
-		defer context.Release()
+	commandOptions, err := commandOptions()
+
+	if err != nil {
+		return err
 	}
 
-	command, err := runner.NewCommand(args[2:])
+	command, err := runner.NewCommand(args[2:], commandOptions...)
 
 	if err != nil {
 		return fmt.Errorf("failed to parse command: %w", err)
 	}
 
-	hass := hass.NewHass(
-		viper.GetString("bearer"),
-		viper.GetString("host"),
-		args[0],
-	)
+	// the kill button of MQTT cancels the command like hass-run kill
+	hassClient, closeHass := newPublisher(args[0], hass.WithKillHandler(func() {
+		log.Printf("Kill button pressed")
+		syscall.Kill(os.Getpid(), runner.CancelSignal)
+	}))
+	defer closeHass()
+
+	hooks, err := configuredHooks()
+
+	if err != nil {
+		return err
+	}
+
+	events := viper.GetStringSlice("events")
+
+	// the WebSocket API only carries events and service calls, states are
+	// still set through the REST API
+	var api interface {
+		runner.EventBus
+		runner.ServiceCaller
+	} = newHass(args[0])
+
+	if viper.GetString("transport") == transportWebSocket && (len(events) > 0 || len(hooks) > 0) {
+		ws := newWebSocket(args[0])
+		defer ws.Close()
+
+		api = ws
+	}
+
+	clearSpooled(args[0])
+
+	// delivering the states of other entities must not delay the command
+	go deliverSpooled(args[0])
+
+	options, err := runnerOptions(args[0], args[1])
+
+	if err != nil {
+		return err
+	}
+
+	options = append(options, runner.WithOnStart(notifyReady))
+
+	if len(events) > 0 {
+		options = append(options, runner.WithEvents(api, events, viper.GetInt("event-output-lines")))
+	}
+
+	if len(hooks) > 0 {
+		options = append(options, runner.WithHooks(api, hooks...))
+	}
+
+	// in the foreground, hass-run stands for the command
+	if viper.GetBool("nodaemon") {
+		options = append(
+			options,
+			runner.WithPassthrough(os.Stdout, os.Stderr),
+			runner.WithSignalForwarding(),
+		)
+	}
 
 	cmdRunner := runner.NewRunner(
 		command,
-		hass,
+		hassClient,
+		options...,
 	)
 
 	cmdRunner.Run()
 
+	unlock()
+
+	// in the foreground the exit status is not held back, an undelivered
+	// final state staying spooled for the next run
+	if viper.GetBool("nodaemon") {
+		if status := cmdRunner.ExitStatus(); status != 0 {
+			return &exitError{code: status}
+		}
+
+		return nil
+	}
+
+	cmdRunner.Linger()
+
 	return nil
 }
+
+func commandOptions() ([]runner.CommandOption, error) {
+	options := []runner.CommandOption{}
+
+	if cwd := viper.GetString("cwd"); cwd != "" {
+		info, err := os.Stat(cwd)
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid working directory: %w", err)
+		}
+
+		if !info.IsDir() {
+			return nil, fmt.Errorf("invalid working directory: %s is not a directory", cwd)
+		}
+
+		options = append(options, runner.WithDir(cwd))
+	}
+
+	if viper.GetBool("clear-env") {
+		options = append(options, runner.WithClearEnv())
+	}
+
+	if envFile := viper.GetString("env-file"); envFile != "" {
+		env, err := runner.ReadEnvFile(envFile)
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid env file: %w", err)
+		}
+
+		options = append(options, runner.WithEnv(env...))
+	}
+
+	env := viper.GetStringSlice("env")
+	err := runner.ValidateEnv(env)
+
+	if err != nil {
+		return nil, fmt.Errorf("invalid environment: %w", err)
+	}
+
+	options = append(options, runner.WithEnv(env...))
+
+	credential, err := runner.LookupCredential(
+		viper.GetString("user"),
+		viper.GetString("group"),
+	)
+
+	if err != nil {
+		return nil, fmt.Errorf("invalid user/group: %w", err)
+	}
+
+	if credential != nil {
+		options = append(options, runner.WithCredential(credential))
+	}
+
+	return options, nil
+}
+
+func runnerOptions(entity string, pidFile string) ([]runner.Option, error) {
+	retentionMode, err := runner.ParseRetentionMode(viper.GetString("output-keep"))
+
+	if err != nil {
+		return nil, fmt.Errorf("invalid output policy: %w", err)
+	}
+
+	killSignal, err := runner.ParseSignal(viper.GetString("kill-signal"))
+
+	if err != nil {
+		return nil, fmt.Errorf("invalid kill signal: %w", err)
+	}
+
+	options := []runner.Option{
+		runner.WithOutputPolicy(runner.OutputPolicy{
+			MaxBytes: viper.GetInt("output-max-bytes"),
+			MaxLines: viper.GetInt("output-max-lines"),
+			Mode:     retentionMode,
+		}),
+		runner.WithPublishInterval(viper.GetDuration("publish-interval")),
+		runner.WithSpool(
+			hass.NewSpool(viper.GetString("spool-dir"), entity),
+			viper.GetDuration("linger"),
+		),
+		runner.WithSink(runner.NewStateFile(stateFilePath(pidFile))),
+		runner.WithTimeout(viper.GetDuration("timeout")),
+		runner.WithTermination(runner.TerminationPolicy{
+			Signal:      killSignal,
+			GracePeriod: viper.GetDuration("grace-period"),
+		}),
+	}
+
+	if viper.GetBool("separate-streams") {
+		options = append(options, runner.WithSeparateStreams())
+	}
+
+	if viper.GetBool("structured-output") {
+		options = append(options, runner.WithStructuredOutput())
+	}
+
+	if pattern := viper.GetString("progress"); pattern != "" {
+		parser, err := runner.NewProgressParser(pattern)
+
+		if err != nil {
+			return nil, err
+		}
+
+		options = append(options, runner.WithProgress(parser))
+	}
+
+	if logDir := viper.GetString("log-dir"); logDir != "" {
+		logDir, err = filepath.Abs(logDir)
+
+		if err == nil {
+			err = ensurePrivateDir(logDir)
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid log directory: %w", err)
+		}
+
+		options = append(options, runner.WithLogFile(runner.NewLogFile(
+			logDir,
+			entity,
+			viper.GetInt64("log-max-size"),
+			viper.GetInt("log-max-files"),
+		)))
+	}
+
+	return options, nil
+}
@@ -0,0 +1,235 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+
+	"github.com/simon-watiau/hass-run/hass"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var serveCmd = &cobra.Command{
+	Use:          "serve",
+	Short:        "Start and kill jobs on events of HomeAssistant",
+	Long:         `Listen to the events of HomeAssistant through its WebSocket API, starting the job of the configuration file named by the job data of the start events and killing the one of the stop events. Events with an agent data are only handled by the hass-run serve of that name, so that several hosts can run jobs.`,
+	Args:         cobra.NoArgs,
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return serve(cmd)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(serveCmd)
+
+	hostname, _ := os.Hostname()
+
+	serveCmd.Flags().StringP("host", "f", "", "HomeAssistant host (e.g https://hass.fr)")
+	serveCmd.Flags().StringP("bearer", "b", "", "Bearer token for HomeAssistant")
+	serveCmd.Flags().String("agent", hostname, "Name of this agent, matched against the agent data of the events")
+	serveCmd.Flags().String("start-event", "hass_run_start", "Type of the events starting jobs")
+	serveCmd.Flags().String("stop-event", "hass_run_stop", "Type of the events killing jobs")
+	serveCmd.Flags().String("entity", "", "Entity reporting whether the agent is serving (optional)")
+	serveCmd.Flags().Duration("request-timeout", hass.DefaultTimeout, "Timeout of a single HomeAssistant request (0 for no timeout)")
+	serveCmd.Flags().Int("retries", 5, "Maximum number of attempts of a HomeAssistant request")
+}
+
+// jobAgent starts and kills jobs by running hass-run run and kill, which
+// report their state and apply their concurrency policy.
+type jobAgent struct {
+	name       string
+	executable string
+	jobs       map[string]jobConfig
+	// flags given to serve that run needs too
+	forwarded []string
+}
+
+func serve(cmd *cobra.Command) error {
+	err := viper.ReadInConfig()
+
+	if err != nil {
+		return err
+	}
+
+	jobs, err := loadJobs()
+
+	if err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	if len(jobs) == 0 {
+		return errors.New("invalid configuration: no jobs are configured")
+	}
+
+	err = hass.ValidateHostAndBearer(
+		viper.GetString("host"),
+		viper.GetString("bearer"),
+	)
+
+	if err != nil {
+		return fmt.Errorf("invalid host/bearer: %w", err)
+	}
+
+	entity := viper.GetString("entity")
+
+	if entity != "" {
+		err = hass.ValidateEntityName(entity)
+
+		if err != nil {
+			return fmt.Errorf("invalid configuration: %w", err)
+		}
+	}
+
+	executable, err := os.Executable()
+
+	if err != nil {
+		return fmt.Errorf("failed to locate hass-run: %w", err)
+	}
+
+	agent := &jobAgent{
+		name:       viper.GetString("agent"),
+		executable: executable,
+		jobs:       jobs,
+	}
+
+	for _, name := range []string{"host", "bearer", "request-timeout", "retries"} {
+		if flag := cmd.Flags().Lookup(name); flag.Changed {
+			agent.forwarded = append(agent.forwarded, "--"+name+"="+flag.Value.String())
+		}
+	}
+
+	ws := hass.NewWebSocket(newHass(entity))
+
+	err = ws.Connect()
+
+	if err != nil {
+		ws.Close()
+		return fmt.Errorf("failed to connect to the WebSocket API: %w", err)
+	}
+
+	defer ws.Close()
+
+	for eventType, action := range map[string]string{
+		viper.GetString("start-event"): "run",
+		viper.GetString("stop-event"):  "kill",
+	} {
+		err = ws.SubscribeEvents(eventType, agent.handler(action))
+
+		if err != nil {
+			return fmt.Errorf("failed to subscribe to %s events: %w", eventType, err)
+		}
+	}
+
+	log.Printf("Serving jobs %s as %s", jobNames(jobs), agent.name)
+
+	agent.report(entity, "on")
+	defer agent.report(entity, "off")
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	sig := <-signals
+	log.Printf("Received %s, stopping", sig)
+
+	return nil
+}
+
+// handler returns the handler of the events running hass-run action on the
+// job they name.
+func (a *jobAgent) handler(action string) func(hass.Event) {
+	return func(event hass.Event) {
+		var data struct {
+			Job   string `json:"job"`
+			Agent string `json:"agent"`
+		}
+
+		err := json.Unmarshal(event.Data, &data)
+
+		if err != nil {
+			log.Printf("Ignoring %s event: invalid data: %s", event.EventType, err.Error())
+			return
+		}
+
+		if data.Agent != "" && data.Agent != a.name {
+			return
+		}
+
+		// viper keys are case insensitive
+		job := strings.ToLower(data.Job)
+
+		if _, ok := a.jobs[job]; !ok {
+			log.Printf("Ignoring %s event: unknown job %q", event.EventType, data.Job)
+			return
+		}
+
+		go a.spawn(action, job)
+	}
+}
+
+// spawn runs hass-run action on job, which returns once the job started or
+// was killed.
+func (a *jobAgent) spawn(action string, job string) {
+	log.Printf("Running %s of job %s", action, job)
+
+	args := []string{action, "--job", job}
+
+	// kill does not contact HomeAssistant
+	if action == "run" {
+		args = append(args, a.forwarded...)
+	}
+
+	output, err := exec.Command(a.executable, args...).CombinedOutput()
+
+	if err != nil {
+		log.Printf(
+			"Failed to %s job %s: %s: %s",
+			action,
+			job,
+			err.Error(),
+			strings.TrimSpace(string(output)),
+		)
+	}
+}
+
+// report sets the state of entity, if any, to state.
+func (a *jobAgent) report(entity string, state string) {
+	if entity == "" {
+		return
+	}
+
+	names := make([]string, 0, len(a.jobs))
+
+	for name := range a.jobs {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	content, err := json.Marshal(map[string]interface{}{
+		"state": state,
+		"attributes": map[string]interface{}{
+			"agent": a.name,
+			"jobs":  names,
+		},
+	})
+
+	if err != nil {
+		log.Printf("Failed to marshal payload: %s", err.Error())
+		return
+	}
+
+	err = newHass(entity).UpdateState(string(content))
+
+	if err != nil {
+		log.Printf("Failed to report state: %s", err.Error())
+	}
+}
@@ -0,0 +1,193 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/simon-watiau/hass-run/hass"
+	"github.com/stretchr/testify/suite"
+)
+
+// fakeEventAPI implements the subset of the WebSocket API of Home-Assistant
+// used by serve: authentication and event subscriptions.
+type fakeEventAPI struct {
+	server        *httptest.Server
+	mutex         sync.Mutex
+	conn          *websocket.Conn
+	subscriptions map[string]interface{}
+}
+
+func newFakeEventAPI() *fakeEventAPI {
+	api := &fakeEventAPI{subscriptions: map[string]interface{}{}}
+
+	upgrader := websocket.Upgrader{}
+
+	api.server = httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		conn, err := upgrader.Upgrade(res, req, nil)
+
+		if err != nil {
+			return
+		}
+
+		api.serve(conn)
+	}))
+
+	return api
+}
+
+func (api *fakeEventAPI) serve(conn *websocket.Conn) {
+	defer conn.Close()
+
+	conn.WriteJSON(map[string]interface{}{"type": "auth_required"})
+
+	var auth map[string]interface{}
+
+	if conn.ReadJSON(&auth) != nil {
+		return
+	}
+
+	api.mutex.Lock()
+	api.conn = conn
+	conn.WriteJSON(map[string]interface{}{"type": "auth_ok"})
+	api.mutex.Unlock()
+
+	for {
+		var command map[string]interface{}
+
+		if conn.ReadJSON(&command) != nil {
+			return
+		}
+
+		api.mutex.Lock()
+
+		if command["type"] == "subscribe_events" {
+			api.subscriptions[command["event_type"].(string)] = command["id"]
+		}
+
+		conn.WriteJSON(map[string]interface{}{"id": command["id"], "type": "result", "success": true, "result": nil})
+		api.mutex.Unlock()
+	}
+}
+
+// event sends an event of type eventType to its subscription.
+func (api *fakeEventAPI) event(eventType string, data map[string]interface{}) {
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
+
+	api.conn.WriteJSON(map[string]interface{}{
+		"id":   api.subscriptions[eventType],
+		"type": "event",
+		"event": map[string]interface{}{
+			"event_type": eventType,
+			"data":       data,
+			"time_fired": time.Now(),
+		},
+	})
+}
+
+type ServeTestSuite struct {
+	suite.Suite
+	api   *fakeEventAPI
+	ws    *hass.WebSocket
+	calls string
+}
+
+func (suite *ServeTestSuite) SetupTest() {
+	dir := suite.T().TempDir()
+
+	// hass-run is replaced by a script recording its arguments
+	suite.calls = filepath.Join(dir, "calls")
+	executable := filepath.Join(dir, "hass-run")
+
+	suite.Require().Nil(ioutil.WriteFile(
+		executable,
+		[]byte("#!/bin/sh\necho \"$@\" >> "+suite.calls+"\n"),
+		0755,
+	))
+
+	agent := &jobAgent{
+		name:       "pi",
+		executable: executable,
+		jobs: map[string]jobConfig{
+			"backup": {name: "backup", entity: "shell.backup"},
+		},
+		forwarded: []string{"--host=http://hass.local"},
+	}
+
+	suite.api = newFakeEventAPI()
+	suite.ws = hass.NewWebSocket(
+		hass.NewHass("ABC", suite.api.server.URL, "", hass.WithTimeout(time.Second)),
+		hass.WithPingInterval(0),
+	)
+
+	suite.Require().Nil(suite.ws.Connect())
+	suite.Require().Nil(suite.ws.SubscribeEvents("hass_run_start", agent.handler("run")))
+	suite.Require().Nil(suite.ws.SubscribeEvents("hass_run_stop", agent.handler("kill")))
+}
+
+func (suite *ServeTestSuite) TearDownTest() {
+	suite.ws.Close()
+	suite.api.server.Close()
+}
+
+// spawned waits for hass-run to be run count times, and no more, returning
+// the arguments of each run.
+func (suite *ServeTestSuite) spawned(count int) []string {
+	var calls []string
+
+	read := func() int {
+		content, _ := ioutil.ReadFile(suite.calls)
+		calls = strings.Split(strings.TrimSpace(string(content)), "\n")
+
+		if len(content) == 0 {
+			return 0
+		}
+
+		return len(calls)
+	}
+
+	suite.Eventually(func() bool { return read() >= count }, 5*time.Second, 10*time.Millisecond)
+	suite.Never(func() bool { return read() > count }, 200*time.Millisecond, 10*time.Millisecond)
+
+	return calls
+}
+
+func (suite *ServeTestSuite) TestStartJob() {
+	suite.api.event("hass_run_start", map[string]interface{}{"job": "Backup"})
+
+	suite.Equal([]string{"run --job backup --host=http://hass.local"}, suite.spawned(1))
+}
+
+func (suite *ServeTestSuite) TestStopJob() {
+	suite.api.event("hass_run_stop", map[string]interface{}{"job": "backup", "agent": "pi"})
+
+	// kill does not contact HomeAssistant
+	suite.Equal([]string{"kill --job backup"}, suite.spawned(1))
+}
+
+func (suite *ServeTestSuite) TestOtherAgent() {
+	suite.api.event("hass_run_start", map[string]interface{}{"job": "backup", "agent": "nas"})
+	suite.api.event("hass_run_stop", map[string]interface{}{"job": "backup"})
+
+	// events are handled in order, the first one was ignored
+	suite.Equal([]string{"kill --job backup"}, suite.spawned(1))
+}
+
+func (suite *ServeTestSuite) TestUnknownJob() {
+	suite.api.event("hass_run_start", map[string]interface{}{"job": "restore"})
+	suite.api.event("hass_run_start", map[string]interface{}{"job": 42})
+	suite.api.event("hass_run_stop", map[string]interface{}{"job": "backup"})
+
+	suite.Equal([]string{"kill --job backup"}, suite.spawned(1))
+}
+
+func TestServeTestSuite(t *testing.T) {
+	suite.Run(t, new(ServeTestSuite))
+}
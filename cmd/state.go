@@ -0,0 +1,7 @@
+package cmd
+
+// stateFilePath returns the path of the state file kept by the runner next to
+// the PID file.
+func stateFilePath(pidFile string) string {
+	return pidFile + ".state"
+}
@@ -0,0 +1,165 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/simon-watiau/hass-run/hass"
+	"github.com/simon-watiau/hass-run/pid"
+	"github.com/simon-watiau/hass-run/runner"
+	"github.com/spf13/cobra"
+)
+
+const (
+	statusExitSuccess = 0
+	statusExitFailure = 1
+	statusExitRunning = 2
+	statusExitUnknown = 3
+)
+
+const stateUnknown = "unknown"
+
+var statusCmd = &cobra.Command{
+	Use:          "status [flags] [entity] [PIDFile]",
+	Short:        "Show the state of a command",
+	Args:         cobra.ExactArgs(2),
+	ArgAliases:   []string{"entity", "PIDFile"},
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		err := hass.ValidateEntityName(args[0])
+
+		if err != nil {
+			return fmt.Errorf("invalid configuration: %w", err)
+		}
+
+		return status(cmd, args)
+	},
+}
+
+type jobStatus struct {
+	Entity    string    `json:"entity"`
+	State     string    `json:"state"`
+	PID       int       `json:"pid"`
+	StartedAt time.Time `json:"started_at"`
+	EndedAt   time.Time `json:"ended_at"`
+	Duration  int       `json:"duration"`
+	ExitCode  int       `json:"exit_code"`
+	Output    string    `json:"output"`
+}
+
+func init() {
+	rootCmd.AddCommand(statusCmd)
+
+	statusCmd.Flags().Bool("json", false, "Print the status as JSON")
+	statusCmd.Flags().Int("lines", 10, "Number of output lines to print")
+}
+
+func status(cmd *cobra.Command, args []string) error {
+	lines, _ := cmd.Flags().GetInt("lines")
+	asJSON, _ := cmd.Flags().GetBool("json")
+
+	current := jobStatus{
+		Entity: args[0],
+		State:  stateUnknown,
+	}
+
+	payload, err := runner.NewStateFile(stateFilePath(args[1])).Load()
+
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	if err == nil {
+		current.State = payload.State
+		current.StartedAt = payload.Attributes.StartedAt
+		current.EndedAt = payload.Attributes.EndedAt
+		current.Duration = payload.Attributes.Duration
+		current.ExitCode = payload.Attributes.ExitCode
+		current.Output = lastLines(payload.Attributes.Output, lines)
+	}
+
+	holder, err := pid.Holder(args[1])
+
+	if holder != 0 || errors.Is(err, pid.ErrLocked) {
+		current.PID = holder
+
+		if current.State != runner.StateRunning {
+			// the daemon started but did not publish its first state yet
+			current.State = runner.StateRunning
+			current.StartedAt = time.Time{}
+			current.Output = ""
+		}
+	} else if current.State == runner.StateRunning {
+		// the daemon died without publishing its final state
+		current.State = stateUnknown
+	}
+
+	if current.State == runner.StateRunning && !current.StartedAt.IsZero() {
+		current.Duration = int(time.Since(current.StartedAt).Seconds())
+	}
+
+	if asJSON {
+		bytes, err := json.Marshal(current)
+
+		if err != nil {
+			return fmt.Errorf("failed to marshal status: %w", err)
+		}
+
+		fmt.Println(string(bytes))
+	} else {
+		printStatus(current)
+	}
+
+	switch current.State {
+	case runner.StateSuccess:
+		return nil
+	case runner.StateRunning:
+		return &exitError{code: statusExitRunning}
+	case stateUnknown, runner.StateStale:
+		return &exitError{code: statusExitUnknown}
+	}
+
+	return &exitError{code: statusExitFailure}
+}
+
+func printStatus(current jobStatus) {
+	fmt.Printf("Entity:     %s\n", current.Entity)
+	fmt.Printf("State:      %s\n", current.State)
+
+	if current.PID != 0 {
+		fmt.Printf("PID:        %d\n", current.PID)
+	}
+
+	if !current.StartedAt.IsZero() {
+		fmt.Printf("Started at: %s\n", current.StartedAt.Format(time.RFC3339))
+		fmt.Printf("Duration:   %s\n", time.Duration(current.Duration)*time.Second)
+	}
+
+	if !current.EndedAt.IsZero() {
+		fmt.Printf("Ended at:   %s\n", current.EndedAt.Format(time.RFC3339))
+		fmt.Printf("Exit code:  %d\n", current.ExitCode)
+	}
+
+	if current.Output != "" {
+		fmt.Printf("\n%s", current.Output)
+	}
+}
+
+// lastLines returns the last count lines of output.
+func lastLines(output string, count int) string {
+	lines := strings.SplitAfter(output, "\n")
+
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	if count >= 0 && len(lines) > count {
+		lines = lines[len(lines)-count:]
+	}
+
+	return strings.Join(lines, "")
+}
@@ -4,7 +4,11 @@ go 1.17
 
 require (
 	bou.ke/monkey v1.0.2
+	github.com/eclipse/paho.mqtt.golang v1.4.2
+	github.com/gorilla/websocket v1.5.0
 	github.com/sevlyar/go-daemon v0.1.5
+	github.com/spf13/cast v1.4.1
+	github.com/spf13/pflag v1.0.5
 	github.com/stretchr/testify v1.7.1
 )
 
@@ -26,11 +30,11 @@ require (
 	github.com/pelletier/go-toml v1.9.4 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
 	github.com/spf13/afero v1.6.0 // indirect
-	github.com/spf13/cast v1.4.1 // indirect
 	github.com/spf13/jwalterweatherman v1.1.0 // indirect
-	github.com/spf13/pflag v1.0.5 // indirect
 	github.com/stretchr/objx v0.1.1 // indirect
 	github.com/subosito/gotenv v1.2.0 // indirect
+	golang.org/x/net v0.0.0-20210813160813-60bc85c4be6d // indirect
+	golang.org/x/sync v0.0.0-20210220032951-036812b2e83c // indirect
 	golang.org/x/text v0.3.7 // indirect
 	gopkg.in/check.v1 v1.0.0-20190902080502-41f04d3bba15 // indirect
 	gopkg.in/ini.v1 v1.66.2 // indirect
@@ -0,0 +1,20 @@
+package hass
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// FireEvent fires an event of type eventType on the event bus, data being
+// marshalled as the data of the event.
+func (h *Hass) FireEvent(eventType string, data interface{}) error {
+	content, err := json.Marshal(data)
+
+	if err != nil {
+		return fmt.Errorf("failed to marshal event data: %w", err)
+	}
+
+	return h.retry.Do(func() error {
+		return h.post(fmt.Sprintf("/api/events/%s", eventType), content)
+	})
+}
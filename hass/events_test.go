@@ -0,0 +1,59 @@
+package hass
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type EventsTestSuite struct {
+	suite.Suite
+}
+
+func (suite *EventsTestSuite) TestFireEvent() {
+	callReceived := false
+
+	testServer := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		callReceived = true
+
+		suite.Equal("POST", req.Method)
+		suite.Equal("Bearer ABC", req.Header.Get("Authorization"))
+		suite.Equal("/api/events/hass_run_started", req.URL.Path)
+
+		body, err := ioutil.ReadAll(req.Body)
+		suite.Nil(err)
+		suite.JSONEq(`{"entity_id": "a.b"}`, string(body))
+
+		res.WriteHeader(http.StatusOK)
+	}))
+
+	defer testServer.Close()
+
+	hass := NewHass("ABC", testServer.URL, "a.b")
+
+	suite.Nil(hass.FireEvent("hass_run_started", map[string]string{"entity_id": "a.b"}))
+	suite.True(callReceived)
+}
+
+func (suite *EventsTestSuite) TestFireEventFailure() {
+	testServer := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		res.WriteHeader(http.StatusBadRequest)
+		res.Write([]byte("invalid"))
+	}))
+
+	defer testServer.Close()
+
+	hass := NewHass("ABC", testServer.URL, "a.b")
+
+	var statusErr *StatusError
+
+	suite.ErrorAs(hass.FireEvent("hass_run_started", nil), &statusErr)
+	suite.Equal(http.StatusBadRequest, statusErr.StatusCode)
+}
+
+func TestEventsTestSuite(t *testing.T) {
+	suite.Run(t, new(EventsTestSuite))
+}
@@ -1,19 +1,93 @@
 package hass
 
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+const DefaultTimeout = 10 * time.Second
+
 type Hass struct {
 	bearer   string
 	endpoint string
 	entity   string
+	client   *http.Client
+	retry    RetryPolicy
+}
+
+type Option func(h *Hass)
+
+func WithTimeout(timeout time.Duration) Option {
+	return func(h *Hass) {
+		h.client.Timeout = timeout
+	}
+}
+
+func WithRetryPolicy(retry RetryPolicy) Option {
+	return func(h *Hass) {
+		h.retry = retry
+	}
 }
 
 func NewHass(
 	bearer string,
 	endpoint string,
 	entity string,
+	options ...Option,
 ) *Hass {
-	return &Hass{
+	hass := &Hass{
 		bearer:   bearer,
 		endpoint: endpoint,
 		entity:   entity,
+		client: &http.Client{
+			Timeout: DefaultTimeout,
+		},
+	}
+
+	for _, option := range options {
+		option(hass)
 	}
+
+	return hass
+}
+
+func (h *Hass) Entity() string {
+	return h.entity
+}
+
+// post sends body to the REST API at path, failing unless the response is a
+// success.
+func (h *Hass) post(path string, body []byte) error {
+	req, err := http.NewRequest(
+		"POST",
+		h.endpoint+path,
+		bytes.NewBuffer(body),
+	)
+
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+h.bearer)
+
+	response, err := h.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("request failed: %w", err)
+	}
+
+	defer response.Body.Close()
+
+	if response.StatusCode != 200 && response.StatusCode != 201 {
+		body, _ := ioutil.ReadAll(response.Body)
+		return &StatusError{
+			StatusCode: response.StatusCode,
+			Body:       string(body),
+		}
+	}
+
+	return nil
 }
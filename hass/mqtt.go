@@ -0,0 +1,259 @@
+package hass
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+const DefaultDiscoveryPrefix = "homeassistant"
+
+// MQTTTopicPrefix is the prefix of the state and command topics.
+const MQTTTopicPrefix = "hass-run"
+
+// stateRunning is the state of the entity while the command runs.
+const stateRunning = "running"
+
+// payloadPress is sent by Home-Assistant to the command topic of a button.
+const payloadPress = "PRESS"
+
+// MQTT publishes the state of an entity through an MQTT broker, along with
+// the discovery configurations making Home-Assistant create a device with a
+// sensor holding the state and attributes, a binary sensor telling whether
+// the command is running and a button killing it. Every message is retained,
+// so that the entities survive restarts of Home-Assistant.
+type MQTT struct {
+	client          mqtt.Client
+	entity          string
+	objectID        string
+	discoveryPrefix string
+	username        string
+	password        string
+	timeout         time.Duration
+	onKill          func()
+	mutex           sync.Mutex
+	connected       chan struct{}
+}
+
+type MQTTOption func(m *MQTT)
+
+func WithDiscoveryPrefix(prefix string) MQTTOption {
+	return func(m *MQTT) {
+		m.discoveryPrefix = prefix
+	}
+}
+
+func WithCredentials(username string, password string) MQTTOption {
+	return func(m *MQTT) {
+		m.username = username
+		m.password = password
+	}
+}
+
+func WithMQTTTimeout(timeout time.Duration) MQTTOption {
+	return func(m *MQTT) {
+		m.timeout = timeout
+	}
+}
+
+// WithKillHandler makes the kill button call onKill when pressed.
+func WithKillHandler(onKill func()) MQTTOption {
+	return func(m *MQTT) {
+		m.onKill = onKill
+	}
+}
+
+// NewMQTT creates a client of broker (e.g tcp://localhost:1883) publishing
+// the state of entity, whose domain is ignored: Home-Assistant names the
+// entities after its object id.
+func NewMQTT(broker string, entity string, options ...MQTTOption) *MQTT {
+	m := &MQTT{
+		entity:          entity,
+		objectID:        entity[strings.Index(entity, ".")+1:],
+		discoveryPrefix: DefaultDiscoveryPrefix,
+		timeout:         DefaultTimeout,
+		connected:       make(chan struct{}),
+	}
+
+	for _, option := range options {
+		option(m)
+	}
+
+	clientOptions := mqtt.NewClientOptions().
+		AddBroker(broker).
+		SetClientID(fmt.Sprintf("hass-run-%s-%d", m.objectID, os.Getpid())).
+		SetUsername(m.username).
+		SetPassword(m.password).
+		SetConnectTimeout(m.timeout).
+		SetConnectRetry(true).
+		SetConnectRetryInterval(5*time.Second).
+		SetAutoReconnect(true).
+		// the command is not running anymore if hass-run vanishes
+		SetWill(m.topic("running"), "OFF", 1, true).
+		SetOnConnectHandler(m.onConnect)
+
+	m.client = mqtt.NewClient(clientOptions)
+
+	return m
+}
+
+func (m *MQTT) Entity() string {
+	return m.entity
+}
+
+func (m *MQTT) topic(name string) string {
+	return fmt.Sprintf("%s/%s/%s", MQTTTopicPrefix, m.objectID, name)
+}
+
+func (m *MQTT) discoveryTopic(component string) string {
+	return fmt.Sprintf("%s/%s/hass_run/%s/config", m.discoveryPrefix, component, m.objectID)
+}
+
+// Connect connects to the broker, waiting for the connection for at most the
+// timeout. The connection keeps being retried in the background afterwards.
+func (m *MQTT) Connect() error {
+	token := m.client.Connect()
+
+	select {
+	case <-m.connected:
+	case <-time.After(m.timeout):
+		return errors.New("timed out connecting to the MQTT broker")
+	}
+
+	return token.Error()
+}
+
+// onConnect publishes the discovery configurations and subscribes to the
+// kill button, on every (re)connection.
+func (m *MQTT) onConnect(client mqtt.Client) {
+	for topic, config := range m.discoveryConfigs() {
+		content, _ := json.Marshal(config)
+
+		err := m.wait(client.Publish(topic, 1, true, content))
+
+		if err != nil {
+			log.Printf("Failed to publish discovery configuration: %s", err.Error())
+		}
+	}
+
+	if m.onKill != nil {
+		err := m.wait(client.Subscribe(m.topic("kill"), 1, func(client mqtt.Client, message mqtt.Message) {
+			if string(message.Payload()) == payloadPress {
+				m.onKill()
+			}
+		}))
+
+		if err != nil {
+			log.Printf("Failed to subscribe to the kill button: %s", err.Error())
+		}
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	select {
+	case <-m.connected:
+	default:
+		close(m.connected)
+	}
+}
+
+func (m *MQTT) discoveryConfigs() map[string]interface{} {
+	device := map[string]interface{}{
+		"identifiers":  []string{"hass_run_" + m.objectID},
+		"name":         m.objectID,
+		"manufacturer": "hass-run",
+	}
+
+	return map[string]interface{}{
+		m.discoveryTopic("sensor"): map[string]interface{}{
+			"name":                  m.objectID,
+			"object_id":             m.objectID,
+			"unique_id":             "hass_run_" + m.objectID,
+			"state_topic":           m.topic("state"),
+			"json_attributes_topic": m.topic("attributes"),
+			"icon":                  "mdi:console",
+			"device":                device,
+		},
+		m.discoveryTopic("binary_sensor"): map[string]interface{}{
+			"name":         m.objectID + " running",
+			"object_id":    m.objectID + "_running",
+			"unique_id":    "hass_run_" + m.objectID + "_running",
+			"state_topic":  m.topic("running"),
+			"device_class": "running",
+			"device":       device,
+		},
+		m.discoveryTopic("button"): map[string]interface{}{
+			"name":                  m.objectID + " kill",
+			"object_id":             m.objectID + "_kill",
+			"unique_id":             "hass_run_" + m.objectID + "_kill",
+			"command_topic":         m.topic("kill"),
+			"payload_press":         payloadPress,
+			"availability_topic":    m.topic("running"),
+			"payload_available":     "ON",
+			"payload_not_available": "OFF",
+			"icon":                  "mdi:stop",
+			"device":                device,
+		},
+	}
+}
+
+func (m *MQTT) UpdateState(content string) error {
+	var payload struct {
+		State      string          `json:"state"`
+		Attributes json.RawMessage `json:"attributes"`
+	}
+
+	err := json.Unmarshal([]byte(content), &payload)
+
+	if err != nil {
+		return fmt.Errorf("failed to parse payload: %w", err)
+	}
+
+	running := "OFF"
+
+	if payload.State == stateRunning {
+		running = "ON"
+	}
+
+	messages := []struct {
+		topic   string
+		payload interface{}
+	}{
+		{m.topic("attributes"), []byte(payload.Attributes)},
+		{m.topic("state"), payload.State},
+		{m.topic("running"), running},
+	}
+
+	for _, message := range messages {
+		err = m.wait(m.client.Publish(message.topic, 1, true, message.payload))
+
+		if err != nil {
+			return fmt.Errorf("failed to publish %s: %w", message.topic, err)
+		}
+	}
+
+	return nil
+}
+
+func (m *MQTT) wait(token mqtt.Token) error {
+	if !token.WaitTimeout(m.timeout) {
+		return errors.New("timed out waiting for the MQTT broker")
+	}
+
+	return token.Error()
+}
+
+// Close disconnects from the broker, once the pending messages are sent.
+func (m *MQTT) Close() error {
+	m.client.Disconnect(uint(m.timeout / time.Millisecond))
+
+	return nil
+}
@@ -0,0 +1,249 @@
+package hass
+
+import (
+	"encoding/json"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"github.com/stretchr/testify/suite"
+)
+
+// fakeBroker is a local MQTT broker supporting the retained messages and
+// the exact topic subscriptions used by hass-run.
+type fakeBroker struct {
+	listener    net.Listener
+	mutex       sync.Mutex
+	retained    map[string]string
+	subscribers map[string][]net.Conn
+	connects    []*packets.ConnectPacket
+}
+
+func newFakeBroker() (*fakeBroker, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		return nil, err
+	}
+
+	broker := &fakeBroker{
+		listener:    listener,
+		retained:    map[string]string{},
+		subscribers: map[string][]net.Conn{},
+	}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+
+			if err != nil {
+				return
+			}
+
+			go broker.serve(conn)
+		}
+	}()
+
+	return broker, nil
+}
+
+func (b *fakeBroker) url() string {
+	return "tcp://" + b.listener.Addr().String()
+}
+
+func (b *fakeBroker) serve(conn net.Conn) {
+	defer conn.Close()
+
+	for {
+		packet, err := packets.ReadPacket(conn)
+
+		if err != nil {
+			return
+		}
+
+		switch packet := packet.(type) {
+		case *packets.ConnectPacket:
+			b.mutex.Lock()
+			b.connects = append(b.connects, packet)
+			b.mutex.Unlock()
+
+			b.write(conn, packets.NewControlPacket(packets.Connack))
+		case *packets.PublishPacket:
+			if packet.Qos > 0 {
+				ack := packets.NewControlPacket(packets.Puback).(*packets.PubackPacket)
+				ack.MessageID = packet.MessageID
+				b.write(conn, ack)
+			}
+
+			if packet.Retain {
+				b.mutex.Lock()
+				b.retained[packet.TopicName] = string(packet.Payload)
+				b.mutex.Unlock()
+			}
+
+			b.publish(packet.TopicName, string(packet.Payload))
+		case *packets.SubscribePacket:
+			ack := packets.NewControlPacket(packets.Suback).(*packets.SubackPacket)
+			ack.MessageID = packet.MessageID
+			ack.ReturnCodes = make([]byte, len(packet.Topics))
+
+			b.mutex.Lock()
+			for _, topic := range packet.Topics {
+				b.subscribers[topic] = append(b.subscribers[topic], conn)
+			}
+			b.mutex.Unlock()
+
+			b.write(conn, ack)
+		case *packets.PingreqPacket:
+			b.write(conn, packets.NewControlPacket(packets.Pingresp))
+		case *packets.DisconnectPacket:
+			return
+		}
+	}
+}
+
+func (b *fakeBroker) write(conn net.Conn, packet packets.ControlPacket) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	packet.Write(conn)
+}
+
+// publish sends a message to the subscribers of topic.
+func (b *fakeBroker) publish(topic string, payload string) {
+	b.mutex.Lock()
+	subscribers := b.subscribers[topic]
+	b.mutex.Unlock()
+
+	for _, conn := range subscribers {
+		packet := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+		packet.TopicName = topic
+		packet.Payload = []byte(payload)
+
+		b.write(conn, packet)
+	}
+}
+
+func (b *fakeBroker) message(topic string) (string, bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	payload, ok := b.retained[topic]
+
+	return payload, ok
+}
+
+type MQTTTestSuite struct {
+	suite.Suite
+	broker *fakeBroker
+}
+
+func (suite *MQTTTestSuite) SetupTest() {
+	broker, err := newFakeBroker()
+	suite.Nil(err)
+
+	suite.broker = broker
+}
+
+func (suite *MQTTTestSuite) TearDownTest() {
+	suite.broker.listener.Close()
+}
+
+func (suite *MQTTTestSuite) TestDiscoveryAndState() {
+	client := NewMQTT(
+		suite.broker.url(),
+		"shell.backup",
+		WithCredentials("user", "password"),
+		WithDiscoveryPrefix("ha"),
+		WithMQTTTimeout(time.Second),
+	)
+	defer client.Close()
+
+	suite.Nil(client.Connect())
+
+	suite.broker.mutex.Lock()
+	connect := suite.broker.connects[0]
+	suite.broker.mutex.Unlock()
+
+	suite.Equal("user", connect.Username)
+	suite.Equal("password", string(connect.Password))
+	suite.Equal("hass-run/backup/running", connect.WillTopic)
+	suite.Equal("OFF", string(connect.WillMessage))
+	suite.True(connect.WillRetain)
+
+	var sensor, binarySensor, button map[string]interface{}
+
+	for topic, config := range map[string]*map[string]interface{}{
+		"ha/sensor/hass_run/backup/config":        &sensor,
+		"ha/binary_sensor/hass_run/backup/config": &binarySensor,
+		"ha/button/hass_run/backup/config":        &button,
+	} {
+		payload, ok := suite.broker.message(topic)
+		suite.True(ok, topic)
+		suite.Nil(json.Unmarshal([]byte(payload), config))
+	}
+
+	suite.Equal("hass_run_backup", sensor["unique_id"])
+	suite.Equal("hass-run/backup/state", sensor["state_topic"])
+	suite.Equal("hass-run/backup/attributes", sensor["json_attributes_topic"])
+	suite.Equal("hass-run/backup/running", binarySensor["state_topic"])
+	suite.Equal("hass-run/backup/kill", button["command_topic"])
+	suite.Equal(sensor["device"], button["device"])
+
+	suite.Nil(client.UpdateState(`{"state": "running", "attributes": {"running": false, "output": "a\n"}}`))
+
+	state, _ := suite.broker.message("hass-run/backup/state")
+	suite.Equal("running", state)
+
+	attributes, _ := suite.broker.message("hass-run/backup/attributes")
+	suite.JSONEq(`{"running": false, "output": "a\n"}`, attributes)
+
+	running, _ := suite.broker.message("hass-run/backup/running")
+	suite.Equal("ON", running)
+
+	suite.Nil(client.UpdateState(`{"state": "success", "attributes": {"running": false}}`))
+
+	running, _ = suite.broker.message("hass-run/backup/running")
+	suite.Equal("OFF", running)
+
+	suite.ErrorContains(client.UpdateState(`{`), "failed to parse payload")
+}
+
+func (suite *MQTTTestSuite) TestKillButton() {
+	killed := make(chan struct{}, 1)
+
+	client := NewMQTT(
+		suite.broker.url(),
+		"shell.backup",
+		WithMQTTTimeout(time.Second),
+		WithKillHandler(func() { killed <- struct{}{} }),
+	)
+	defer client.Close()
+
+	suite.Nil(client.Connect())
+
+	suite.broker.publish("hass-run/backup/kill", "PRESS")
+
+	select {
+	case <-killed:
+	case <-time.After(time.Second):
+		suite.Fail("the kill handler was not called")
+	}
+}
+
+func (suite *MQTTTestSuite) TestUnreachableBroker() {
+	address := suite.broker.listener.Addr().String()
+	suite.broker.listener.Close()
+
+	client := NewMQTT("tcp://"+address, "shell.backup", WithMQTTTimeout(100*time.Millisecond))
+	defer client.Close()
+
+	suite.Error(client.Connect())
+	suite.Error(client.UpdateState(`{"state": "running", "attributes": {"running": true}}`))
+}
+
+func TestMQTTTestSuite(t *testing.T) {
+	suite.Run(t, new(MQTTTestSuite))
+}
@@ -0,0 +1,71 @@
+package hass
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+type RetryPolicy struct {
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+type StatusError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("invalid status code: %d != [200, 201]: %s", e.StatusCode, e.Body)
+}
+
+// Do calls fn until it succeeds, fails with a non retryable error or the
+// maximum number of attempts is reached, sleeping with a jittered
+// exponential backoff between attempts.
+func (p RetryPolicy) Do(fn func() error) error {
+	var err error
+
+	for attempt := 0; ; attempt++ {
+		err = fn()
+
+		if err == nil || !retryable(err) || attempt+1 >= p.MaxAttempts {
+			return err
+		}
+
+		time.Sleep(p.Backoff(attempt))
+	}
+}
+
+func (p RetryPolicy) Backoff(attempt int) time.Duration {
+	backoff := p.InitialBackoff
+
+	for i := 0; i < attempt && (p.MaxBackoff <= 0 || backoff < p.MaxBackoff); i++ {
+		backoff *= 2
+	}
+
+	if p.MaxBackoff > 0 && backoff > p.MaxBackoff {
+		backoff = p.MaxBackoff
+	}
+
+	if backoff <= 0 {
+		return 0
+	}
+
+	return backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+}
+
+func retryable(err error) bool {
+	var statusErr *StatusError
+
+	if !errors.As(err, &statusErr) {
+		return true
+	}
+
+	return statusErr.StatusCode == http.StatusRequestTimeout ||
+		statusErr.StatusCode == http.StatusTooManyRequests ||
+		statusErr.StatusCode >= 500
+}
@@ -0,0 +1,63 @@
+package hass
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type RetryTestSuite struct {
+	suite.Suite
+}
+
+func (suite *RetryTestSuite) TestBackoff() {
+	policy := RetryPolicy{
+		InitialBackoff: time.Second,
+		MaxBackoff:     5 * time.Second,
+	}
+
+	for attempt, expected := range []time.Duration{
+		time.Second,
+		2 * time.Second,
+		4 * time.Second,
+		5 * time.Second,
+		5 * time.Second,
+	} {
+		backoff := policy.Backoff(attempt)
+		suite.GreaterOrEqual(backoff, expected/2)
+		suite.LessOrEqual(backoff, expected)
+	}
+}
+
+func (suite *RetryTestSuite) TestDo() {
+	calls := 0
+	policy := RetryPolicy{MaxAttempts: 3}
+
+	err := policy.Do(func() error {
+		calls++
+		return errors.New("FAILED")
+	})
+
+	suite.NotNil(err)
+	suite.Equal(3, calls)
+}
+
+func (suite *RetryTestSuite) TestDoStopsOnPermanentError() {
+	calls := 0
+	policy := RetryPolicy{MaxAttempts: 3}
+
+	err := policy.Do(func() error {
+		calls++
+		return &StatusError{StatusCode: http.StatusBadRequest}
+	})
+
+	suite.NotNil(err)
+	suite.Equal(1, calls)
+}
+
+func TestRetryTestSuite(t *testing.T) {
+	suite.Run(t, new(RetryTestSuite))
+}
@@ -0,0 +1,20 @@
+package hass
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// CallService calls the service domain.service, data being marshalled as the
+// data of the call.
+func (h *Hass) CallService(domain string, service string, data interface{}) error {
+	content, err := json.Marshal(data)
+
+	if err != nil {
+		return fmt.Errorf("failed to marshal service data: %w", err)
+	}
+
+	return h.retry.Do(func() error {
+		return h.post(fmt.Sprintf("/api/services/%s/%s", domain, service), content)
+	})
+}
@@ -0,0 +1,43 @@
+package hass
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type ServicesTestSuite struct {
+	suite.Suite
+}
+
+func (suite *ServicesTestSuite) TestCallService() {
+	callReceived := false
+
+	testServer := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		callReceived = true
+
+		suite.Equal("POST", req.Method)
+		suite.Equal("Bearer ABC", req.Header.Get("Authorization"))
+		suite.Equal("/api/services/notify/mobile_app_phone", req.URL.Path)
+
+		body, err := ioutil.ReadAll(req.Body)
+		suite.Nil(err)
+		suite.JSONEq(`{"message": "failed"}`, string(body))
+
+		res.WriteHeader(http.StatusOK)
+	}))
+
+	defer testServer.Close()
+
+	hass := NewHass("ABC", testServer.URL, "a.b")
+
+	suite.Nil(hass.CallService("notify", "mobile_app_phone", map[string]string{"message": "failed"}))
+	suite.True(callReceived)
+}
+
+func TestServicesTestSuite(t *testing.T) {
+	suite.Run(t, new(ServicesTestSuite))
+}
@@ -0,0 +1,98 @@
+package hass
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const spoolExtension = ".json"
+
+// Spool keeps on disk a state that could not be delivered to Home-Assistant
+// so that it can be sent later on.
+type Spool struct {
+	dir    string
+	entity string
+}
+
+func NewSpool(dir string, entity string) *Spool {
+	return &Spool{
+		dir:    dir,
+		entity: entity,
+	}
+}
+
+func (s *Spool) path() string {
+	return filepath.Join(s.dir, s.entity+spoolExtension)
+}
+
+func (s *Spool) Store(json string) error {
+	if err := os.MkdirAll(s.dir, 0700); err != nil {
+		return fmt.Errorf("failed to create spool directory: %w", err)
+	}
+
+	tmp := s.path() + ".tmp"
+
+	if err := ioutil.WriteFile(tmp, []byte(json), 0600); err != nil {
+		return fmt.Errorf("failed to write spooled state: %w", err)
+	}
+
+	if err := os.Rename(tmp, s.path()); err != nil {
+		return fmt.Errorf("failed to write spooled state: %w", err)
+	}
+
+	return nil
+}
+
+// Load returns the spooled state, or an empty string if there is none.
+func (s *Spool) Load() (string, error) {
+	content, err := ioutil.ReadFile(s.path())
+
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	}
+
+	if err != nil {
+		return "", fmt.Errorf("failed to read spooled state: %w", err)
+	}
+
+	return string(content), nil
+}
+
+func (s *Spool) Clear() error {
+	err := os.Remove(s.path())
+
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to clear spooled state: %w", err)
+	}
+
+	return nil
+}
+
+// SpooledEntities lists the entities having a spooled state in dir.
+func SpooledEntities(dir string) ([]string, error) {
+	files, err := ioutil.ReadDir(dir)
+
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to list spool directory: %w", err)
+	}
+
+	entities := []string{}
+
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), spoolExtension) {
+			continue
+		}
+
+		entities = append(entities, strings.TrimSuffix(file.Name(), spoolExtension))
+	}
+
+	return entities, nil
+}
@@ -0,0 +1,48 @@
+package hass
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type SpoolTestSuite struct {
+	suite.Suite
+}
+
+func (suite *SpoolTestSuite) TestStoreLoadClear() {
+	dir := filepath.Join(suite.T().TempDir(), "spool")
+	spool := NewSpool(dir, "shell.my_command")
+
+	state, err := spool.Load()
+	suite.Nil(err)
+	suite.Equal("", state)
+
+	suite.Nil(spool.Store(`{"state":"success"}`))
+
+	state, err = spool.Load()
+	suite.Nil(err)
+	suite.Equal(`{"state":"success"}`, state)
+
+	entities, err := SpooledEntities(dir)
+	suite.Nil(err)
+	suite.Equal([]string{"shell.my_command"}, entities)
+
+	suite.Nil(spool.Clear())
+	suite.Nil(spool.Clear())
+
+	entities, err = SpooledEntities(dir)
+	suite.Nil(err)
+	suite.Empty(entities)
+}
+
+func (suite *SpoolTestSuite) TestMissingDirectory() {
+	entities, err := SpooledEntities(filepath.Join(suite.T().TempDir(), "missing"))
+	suite.Nil(err)
+	suite.Empty(entities)
+}
+
+func TestSpoolTestSuite(t *testing.T) {
+	suite.Run(t, new(SpoolTestSuite))
+}
@@ -5,6 +5,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/suite"
 )
@@ -95,6 +96,82 @@ func (suite *UpdateStateTestSuite) TestOnHassFailure() {
 	suite.NotNil(err)
 }
 
+func (suite *UpdateStateTestSuite) TestRetriesOnHassFailure() {
+	calls := 0
+
+	testServer := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		calls++
+
+		if calls < 3 {
+			res.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		res.WriteHeader(http.StatusOK)
+	}))
+
+	defer func() { testServer.Close() }()
+
+	hass := NewHass(
+		"bearer",
+		testServer.URL,
+		"entity",
+		WithRetryPolicy(RetryPolicy{
+			MaxAttempts:    3,
+			InitialBackoff: time.Millisecond,
+		}),
+	)
+
+	err := hass.UpdateState("state")
+
+	suite.Nil(err)
+	suite.Equal(3, calls)
+}
+
+func (suite *UpdateStateTestSuite) TestNoRetryOnClientError() {
+	calls := 0
+
+	testServer := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		calls++
+		res.WriteHeader(http.StatusUnauthorized)
+	}))
+
+	defer func() { testServer.Close() }()
+
+	hass := NewHass(
+		"bearer",
+		testServer.URL,
+		"entity",
+		WithRetryPolicy(RetryPolicy{
+			MaxAttempts:    3,
+			InitialBackoff: time.Millisecond,
+		}),
+	)
+
+	err := hass.UpdateState("state")
+
+	suite.NotNil(err)
+	suite.Equal(1, calls)
+}
+
+func (suite *UpdateStateTestSuite) TestTimeout() {
+	testServer := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+		res.WriteHeader(http.StatusOK)
+	}))
+
+	defer func() { testServer.Close() }()
+
+	hass := NewHass(
+		"bearer",
+		testServer.URL,
+		"entity",
+		WithTimeout(10*time.Millisecond),
+	)
+
+	suite.NotNil(hass.UpdateState("state"))
+}
+
 func TestUpdateStateTestSuite(t *testing.T) {
 	suite.Run(t, new(UpdateStateTestSuite))
 }
@@ -0,0 +1,490 @@
+package hass
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const DefaultPingInterval = 30 * time.Second
+
+// reconnectPolicy spaces the attempts to re-establish a lost connection.
+var reconnectPolicy = RetryPolicy{
+	InitialBackoff: time.Second,
+	MaxBackoff:     time.Minute,
+}
+
+var ErrNotConnected = errors.New("not connected to the WebSocket API")
+
+// CommandError is the error returned by Home-Assistant for a failed command.
+type CommandError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *CommandError) Error() string {
+	return fmt.Sprintf("command failed: %s: %s", e.Code, e.Message)
+}
+
+type Event struct {
+	EventType string          `json:"event_type"`
+	Data      json.RawMessage `json:"data"`
+	TimeFired time.Time       `json:"time_fired"`
+}
+
+type wsMessage struct {
+	ID      int             `json:"id"`
+	Type    string          `json:"type"`
+	Success bool            `json:"success"`
+	Result  json.RawMessage `json:"result"`
+	Error   *CommandError   `json:"error"`
+	Event   *Event          `json:"event"`
+	Message string          `json:"message"`
+}
+
+type subscription struct {
+	eventType string
+	handler   func(Event)
+}
+
+// WebSocket talks to the WebSocket API of Home-Assistant over a single
+// long-lived connection, kept alive with pings and re-established when it
+// drops, subscriptions included. It only carries events and service calls:
+// the API has no command setting the state of an entity, so UpdateState is
+// still a request to the REST API, made by the embedded Hass.
+type WebSocket struct {
+	*Hass
+	url           string
+	dialer        *websocket.Dialer
+	pingInterval  time.Duration
+	reconnection  RetryPolicy
+	mutex         sync.Mutex
+	writeMutex    sync.Mutex
+	conn          *websocket.Conn
+	nextID        int
+	pending       map[int]chan wsMessage
+	subscriptions map[int]*subscription
+	closed        bool
+	done          chan struct{}
+}
+
+type WebSocketOption func(w *WebSocket)
+
+func WithPingInterval(interval time.Duration) WebSocketOption {
+	return func(w *WebSocket) {
+		w.pingInterval = interval
+	}
+}
+
+func NewWebSocket(hass *Hass, options ...WebSocketOption) *WebSocket {
+	ws := &WebSocket{
+		Hass:          hass,
+		url:           websocketURL(hass.endpoint),
+		dialer:        &websocket.Dialer{HandshakeTimeout: hass.client.Timeout},
+		pingInterval:  DefaultPingInterval,
+		reconnection:  reconnectPolicy,
+		nextID:        1,
+		pending:       map[int]chan wsMessage{},
+		subscriptions: map[int]*subscription{},
+		done:          make(chan struct{}),
+	}
+
+	for _, option := range options {
+		option(ws)
+	}
+
+	return ws
+}
+
+// websocketURL returns the URL of the WebSocket API of the instance at
+// endpoint.
+func websocketURL(endpoint string) string {
+	parsed, err := url.Parse(endpoint)
+
+	if err != nil {
+		return endpoint
+	}
+
+	switch parsed.Scheme {
+	case "https":
+		parsed.Scheme = "wss"
+	default:
+		parsed.Scheme = "ws"
+	}
+
+	parsed.Path = strings.TrimSuffix(parsed.Path, "/") + "/api/websocket"
+
+	return parsed.String()
+}
+
+// Connect opens and authenticates the connection. When it fails, it keeps
+// trying in the background until Close is called.
+func (w *WebSocket) Connect() error {
+	err := w.connect()
+
+	if err != nil {
+		go w.reconnect()
+	}
+
+	if w.pingInterval > 0 {
+		go w.pingLoop()
+	}
+
+	return err
+}
+
+func (w *WebSocket) connect() error {
+	conn, _, err := w.dialer.Dial(w.url, nil)
+
+	if err != nil {
+		return fmt.Errorf("failed to connect: %w", err)
+	}
+
+	err = w.authenticate(conn)
+
+	if err != nil {
+		conn.Close()
+		return err
+	}
+
+	w.mutex.Lock()
+
+	if w.closed {
+		w.mutex.Unlock()
+		conn.Close()
+		return ErrNotConnected
+	}
+
+	w.conn = conn
+	subscriptions := w.subscriptions
+	w.subscriptions = map[int]*subscription{}
+	w.mutex.Unlock()
+
+	go w.readLoop(conn)
+
+	for _, sub := range subscriptions {
+		err = w.subscribe(sub)
+
+		if err != nil {
+			log.Printf("Failed to subscribe to %s events: %s", sub.eventType, err.Error())
+		}
+	}
+
+	return nil
+}
+
+// deadline returns the deadline of a request sent now, none when requests
+// have no timeout.
+func (w *WebSocket) deadline() time.Time {
+	if w.client.Timeout <= 0 {
+		return time.Time{}
+	}
+
+	return time.Now().Add(w.client.Timeout)
+}
+
+func (w *WebSocket) authenticate(conn *websocket.Conn) error {
+	conn.SetReadDeadline(w.deadline())
+	defer conn.SetReadDeadline(time.Time{})
+
+	var message wsMessage
+
+	err := conn.ReadJSON(&message)
+
+	if err != nil || message.Type != "auth_required" {
+		return fmt.Errorf("failed to authenticate: unexpected handshake (%v)", err)
+	}
+
+	err = conn.WriteJSON(map[string]interface{}{
+		"type":         "auth",
+		"access_token": w.bearer,
+	})
+
+	if err != nil {
+		return fmt.Errorf("failed to authenticate: %w", err)
+	}
+
+	err = conn.ReadJSON(&message)
+
+	if err != nil {
+		return fmt.Errorf("failed to authenticate: %w", err)
+	}
+
+	if message.Type != "auth_ok" {
+		return fmt.Errorf("failed to authenticate: %s", message.Message)
+	}
+
+	return nil
+}
+
+func (w *WebSocket) readLoop(conn *websocket.Conn) {
+	for {
+		var message wsMessage
+
+		err := conn.ReadJSON(&message)
+
+		if err != nil {
+			w.disconnected(conn, err)
+			return
+		}
+
+		w.mutex.Lock()
+		pending, isPending := w.pending[message.ID]
+		sub, isSubscription := w.subscriptions[message.ID]
+		delete(w.pending, message.ID)
+		w.mutex.Unlock()
+
+		if message.Type == "event" && isSubscription && message.Event != nil {
+			sub.handler(*message.Event)
+		} else if isPending {
+			pending <- message
+		}
+	}
+}
+
+// disconnected fails the pending commands and reconnects, unless the
+// connection was closed on purpose.
+func (w *WebSocket) disconnected(conn *websocket.Conn, err error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.conn != conn {
+		return
+	}
+
+	conn.Close()
+	w.conn = nil
+
+	for id, pending := range w.pending {
+		close(pending)
+		delete(w.pending, id)
+	}
+
+	if w.closed {
+		return
+	}
+
+	log.Printf("WebSocket connection lost: %s", err.Error())
+
+	go w.reconnect()
+}
+
+func (w *WebSocket) reconnect() {
+	for attempt := 0; ; attempt++ {
+		select {
+		case <-w.done:
+			return
+		case <-time.After(w.reconnection.Backoff(attempt)):
+		}
+
+		err := w.connect()
+
+		if err == nil {
+			return
+		}
+
+		log.Printf("Failed to reconnect to the WebSocket API: %s", err.Error())
+	}
+}
+
+func (w *WebSocket) pingLoop() {
+	ticker := time.NewTicker(w.pingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-ticker.C:
+		}
+
+		err := w.Ping()
+
+		if err == nil || errors.Is(err, ErrNotConnected) {
+			continue
+		}
+
+		// dropping the connection makes it reconnect
+		w.mutex.Lock()
+		conn := w.conn
+		w.mutex.Unlock()
+
+		if conn != nil {
+			w.disconnected(conn, err)
+		}
+	}
+}
+
+// Command sends a command, its id being set, and returns its result.
+func (w *WebSocket) Command(command map[string]interface{}) (json.RawMessage, error) {
+	return w.send(command, nil)
+}
+
+func (w *WebSocket) send(command map[string]interface{}, sub *subscription) (json.RawMessage, error) {
+	w.mutex.Lock()
+
+	conn := w.conn
+	id := w.nextID
+	w.nextID++
+
+	if conn == nil {
+		// subscriptions are sent once connected
+		if sub != nil {
+			w.subscriptions[id] = sub
+			w.mutex.Unlock()
+			return nil, nil
+		}
+
+		w.mutex.Unlock()
+		return nil, ErrNotConnected
+	}
+
+	response := make(chan wsMessage, 1)
+	w.pending[id] = response
+
+	// events may follow the result, they must find their subscription
+	if sub != nil {
+		w.subscriptions[id] = sub
+	}
+
+	w.mutex.Unlock()
+
+	message := map[string]interface{}{"id": id}
+
+	for key, value := range command {
+		message[key] = value
+	}
+
+	w.writeMutex.Lock()
+	conn.SetWriteDeadline(w.deadline())
+	err := conn.WriteJSON(message)
+	w.writeMutex.Unlock()
+
+	if err == nil {
+		// without a timeout, the result is awaited until the connection
+		// is lost
+		var timeout <-chan time.Time
+
+		if w.client.Timeout > 0 {
+			timer := time.NewTimer(w.client.Timeout)
+			defer timer.Stop()
+
+			timeout = timer.C
+		}
+
+		select {
+		case result, ok := <-response:
+			if !ok {
+				err = errors.New("connection lost before the result")
+			} else if result.Type == "result" && !result.Success {
+				err = result.Error
+			} else {
+				return result.Result, nil
+			}
+		case <-timeout:
+			err = errors.New("timed out waiting for the result")
+		}
+	}
+
+	w.mutex.Lock()
+	delete(w.pending, id)
+
+	// subscriptions that were not refused are sent again on reconnection
+	var commandErr *CommandError
+
+	if errors.As(err, &commandErr) {
+		delete(w.subscriptions, id)
+	}
+
+	w.mutex.Unlock()
+
+	return nil, err
+}
+
+func (w *WebSocket) Ping() error {
+	_, err := w.Command(map[string]interface{}{"type": "ping"})
+
+	return err
+}
+
+// FireEvent fires an event of type eventType on the event bus, through the
+// REST API while disconnected.
+func (w *WebSocket) FireEvent(eventType string, data interface{}) error {
+	_, err := w.Command(map[string]interface{}{
+		"type":       "fire_event",
+		"event_type": eventType,
+		"event_data": data,
+	})
+
+	if errors.Is(err, ErrNotConnected) {
+		return w.Hass.FireEvent(eventType, data)
+	}
+
+	return err
+}
+
+// CallService calls the service domain.service, through the REST API while
+// disconnected.
+func (w *WebSocket) CallService(domain string, service string, data interface{}) error {
+	_, err := w.Command(map[string]interface{}{
+		"type":         "call_service",
+		"domain":       domain,
+		"service":      service,
+		"service_data": data,
+	})
+
+	if errors.Is(err, ErrNotConnected) {
+		return w.Hass.CallService(domain, service, data)
+	}
+
+	return err
+}
+
+// SubscribeEvents calls handler with every event of type eventType, all
+// events if empty, from the read loop of the connection. The subscription
+// is sent once connected and renewed whenever the connection is
+// re-established.
+func (w *WebSocket) SubscribeEvents(eventType string, handler func(Event)) error {
+	return w.subscribe(&subscription{eventType: eventType, handler: handler})
+}
+
+func (w *WebSocket) subscribe(sub *subscription) error {
+	command := map[string]interface{}{"type": "subscribe_events"}
+
+	if sub.eventType != "" {
+		command["event_type"] = sub.eventType
+	}
+
+	_, err := w.send(command, sub)
+
+	return err
+}
+
+func (w *WebSocket) Close() error {
+	w.mutex.Lock()
+
+	if w.closed {
+		w.mutex.Unlock()
+		return nil
+	}
+
+	w.closed = true
+	close(w.done)
+	conn := w.conn
+	w.mutex.Unlock()
+
+	if conn == nil {
+		return nil
+	}
+
+	w.disconnected(conn, nil)
+
+	return nil
+}
@@ -0,0 +1,257 @@
+package hass
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/suite"
+)
+
+// fakeWebSocketAPI implements the subset of the WebSocket API of
+// Home-Assistant used by hass-run.
+type fakeWebSocketAPI struct {
+	server      *httptest.Server
+	token       string
+	mutex       sync.Mutex
+	conns       []*websocket.Conn
+	connections int
+	fired       []map[string]interface{}
+	subscribed  chan map[string]interface{}
+}
+
+func newFakeWebSocketAPI(token string) *fakeWebSocketAPI {
+	api := &fakeWebSocketAPI{
+		token:      token,
+		subscribed: make(chan map[string]interface{}, 10),
+	}
+
+	upgrader := websocket.Upgrader{}
+
+	api.server = httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/api/websocket" {
+			res.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		conn, err := upgrader.Upgrade(res, req, nil)
+
+		if err != nil {
+			return
+		}
+
+		api.serve(conn)
+	}))
+
+	return api
+}
+
+func (api *fakeWebSocketAPI) serve(conn *websocket.Conn) {
+	defer conn.Close()
+
+	conn.WriteJSON(map[string]interface{}{"type": "auth_required"})
+
+	var auth map[string]interface{}
+
+	if conn.ReadJSON(&auth) != nil {
+		return
+	}
+
+	if auth["access_token"] != api.token {
+		conn.WriteJSON(map[string]interface{}{"type": "auth_invalid", "message": "Invalid access token"})
+		return
+	}
+
+	conn.WriteJSON(map[string]interface{}{"type": "auth_ok"})
+
+	api.mutex.Lock()
+	api.conns = append(api.conns, conn)
+	api.connections++
+	api.mutex.Unlock()
+
+	for {
+		var command map[string]interface{}
+
+		if conn.ReadJSON(&command) != nil {
+			return
+		}
+
+		id := command["id"]
+
+		switch command["type"] {
+		case "ping":
+			api.write(conn, map[string]interface{}{"id": id, "type": "pong"})
+		case "fire_event":
+			api.mutex.Lock()
+			api.fired = append(api.fired, command)
+			api.mutex.Unlock()
+
+			api.write(conn, map[string]interface{}{"id": id, "type": "result", "success": true, "result": map[string]interface{}{}})
+		case "subscribe_events":
+			api.write(conn, map[string]interface{}{"id": id, "type": "result", "success": true, "result": nil})
+			api.subscribed <- command
+		default:
+			api.write(conn, map[string]interface{}{
+				"id":      id,
+				"type":    "result",
+				"success": false,
+				"error":   map[string]interface{}{"code": "unknown_command", "message": "Unknown command."},
+			})
+		}
+	}
+}
+
+func (api *fakeWebSocketAPI) write(conn *websocket.Conn, message interface{}) {
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
+
+	conn.WriteJSON(message)
+}
+
+// event sends an event to the last connection.
+func (api *fakeWebSocketAPI) event(id interface{}, eventType string, data interface{}) {
+	api.mutex.Lock()
+	conn := api.conns[len(api.conns)-1]
+	api.mutex.Unlock()
+
+	api.write(conn, map[string]interface{}{
+		"id":   id,
+		"type": "event",
+		"event": map[string]interface{}{
+			"event_type": eventType,
+			"data":       data,
+			"time_fired": time.Now(),
+		},
+	})
+}
+
+// drop closes the last connection.
+func (api *fakeWebSocketAPI) drop() {
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
+
+	api.conns[len(api.conns)-1].Close()
+}
+
+type WebSocketTestSuite struct {
+	suite.Suite
+	api *fakeWebSocketAPI
+}
+
+func (suite *WebSocketTestSuite) SetupTest() {
+	suite.api = newFakeWebSocketAPI("ABC")
+	reconnectPolicy = RetryPolicy{InitialBackoff: 10 * time.Millisecond, MaxBackoff: 10 * time.Millisecond}
+}
+
+func (suite *WebSocketTestSuite) TearDownTest() {
+	suite.api.server.Close()
+}
+
+func (suite *WebSocketTestSuite) newWebSocket(bearer string) *WebSocket {
+	return NewWebSocket(
+		NewHass(bearer, suite.api.server.URL, "a.b", WithTimeout(time.Second)),
+		WithPingInterval(0),
+	)
+}
+
+func (suite *WebSocketTestSuite) TestURL() {
+	suite.Equal("wss://hass.fr/api/websocket", websocketURL("https://hass.fr"))
+	suite.Equal("ws://hass:8123/ha/api/websocket", websocketURL("http://hass:8123/ha/"))
+}
+
+func (suite *WebSocketTestSuite) TestCommands() {
+	ws := suite.newWebSocket("ABC")
+	defer ws.Close()
+
+	suite.Nil(ws.Connect())
+	suite.Nil(ws.Ping())
+	suite.Nil(ws.FireEvent("hass_run_test", map[string]interface{}{"entity_id": "a.b"}))
+
+	suite.api.mutex.Lock()
+	suite.Len(suite.api.fired, 1)
+	suite.Equal("hass_run_test", suite.api.fired[0]["event_type"])
+	suite.api.mutex.Unlock()
+
+	_, err := ws.Command(map[string]interface{}{"type": "unknown"})
+
+	var commandErr *CommandError
+
+	suite.ErrorAs(err, &commandErr)
+	suite.Equal("unknown_command", commandErr.Code)
+}
+
+func (suite *WebSocketTestSuite) TestWithoutTimeout() {
+	ws := NewWebSocket(
+		NewHass("ABC", suite.api.server.URL, "a.b", WithTimeout(0)),
+		WithPingInterval(0),
+	)
+	defer ws.Close()
+
+	suite.Nil(ws.Connect())
+	suite.Nil(ws.Ping())
+}
+
+func (suite *WebSocketTestSuite) TestInvalidToken() {
+	ws := suite.newWebSocket("XYZ")
+	defer ws.Close()
+
+	suite.ErrorContains(ws.Connect(), "Invalid access token")
+	suite.ErrorIs(ws.Ping(), ErrNotConnected)
+}
+
+func (suite *WebSocketTestSuite) TestReconnectAndResubscribe() {
+	ws := suite.newWebSocket("ABC")
+	defer ws.Close()
+
+	events := make(chan Event, 10)
+
+	suite.Nil(ws.Connect())
+	suite.Nil(ws.SubscribeEvents("hass_run_start", func(event Event) {
+		events <- event
+	}))
+
+	subscription := <-suite.api.subscribed
+	suite.Equal("hass_run_start", subscription["event_type"])
+
+	suite.api.event(subscription["id"], "hass_run_start", map[string]interface{}{"job": "backup"})
+
+	event := <-events
+	suite.Equal("hass_run_start", event.EventType)
+	suite.JSONEq(`{"job": "backup"}`, string(event.Data))
+
+	suite.api.drop()
+
+	// the subscription is renewed with a new id
+	subscription = <-suite.api.subscribed
+	suite.Equal("hass_run_start", subscription["event_type"])
+
+	suite.api.event(subscription["id"], "hass_run_start", map[string]interface{}{"job": "again"})
+
+	event = <-events
+	suite.JSONEq(`{"job": "again"}`, string(event.Data))
+
+	suite.Eventually(func() bool { return ws.Ping() == nil }, time.Second, 10*time.Millisecond)
+
+	suite.api.mutex.Lock()
+	suite.Equal(2, suite.api.connections)
+	suite.api.mutex.Unlock()
+}
+
+func (suite *WebSocketTestSuite) TestSubscribeBeforeConnecting() {
+	ws := suite.newWebSocket("ABC")
+	defer ws.Close()
+
+	suite.Nil(ws.SubscribeEvents("", func(event Event) {}))
+	suite.Nil(ws.Connect())
+
+	subscription := <-suite.api.subscribed
+	_, hasType := subscription["event_type"]
+	suite.False(hasType)
+}
+
+func TestWebSocketTestSuite(t *testing.T) {
+	suite.Run(t, new(WebSocketTestSuite))
+}
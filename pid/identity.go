@@ -0,0 +1,134 @@
+package pid
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ErrIdentityMismatch is returned when the process holding a PID is not the
+// run recorded in the PID file, usually because the PID was reused.
+var ErrIdentityMismatch = errors.New("process does not match the PID file")
+
+// Identity is recorded next to the PID file by the process running a
+// command, so that its PID is not mistaken for an unrelated process reusing
+// it.
+type Identity struct {
+	PID       int    `json:"pid"`
+	StartTime uint64 `json:"start_time"`
+	Entity    string `json:"entity"`
+	ArgvHash  string `json:"argv_hash"`
+}
+
+// IdentityPath returns the path of the identity recorded for pidFile.
+func IdentityPath(pidFile string) string {
+	return pidFile + ".meta"
+}
+
+// ArgvHash identifies a command by its arguments.
+func ArgvHash(argv []string) string {
+	sum := sha256.Sum256([]byte(strings.Join(argv, "\x00")))
+
+	return hex.EncodeToString(sum[:8])
+}
+
+// StartTime returns the start time of the process, in clock ticks since
+// boot.
+func StartTime(pid int) (uint64, error) {
+	fields, err := stat(pid)
+
+	if err != nil {
+		return 0, err
+	}
+
+	// starttime is the 22nd field, fields starting at the 3rd one
+	if len(fields) < 20 {
+		return 0, fmt.Errorf("malformed stat for process %d", pid)
+	}
+
+	return strconv.ParseUint(fields[19], 10, 64)
+}
+
+// CurrentIdentity returns the identity of the current process running argv
+// for entity.
+func CurrentIdentity(entity string, argv []string) (Identity, error) {
+	startTime, err := StartTime(os.Getpid())
+
+	if err != nil {
+		return Identity{}, fmt.Errorf("failed to read process start time: %w", err)
+	}
+
+	return Identity{
+		PID:       os.Getpid(),
+		StartTime: startTime,
+		Entity:    entity,
+		ArgvHash:  ArgvHash(argv),
+	}, nil
+}
+
+// WriteIdentity records identity next to pidFile.
+func WriteIdentity(pidFile string, identity Identity) error {
+	content, err := json.Marshal(identity)
+
+	if err != nil {
+		return err
+	}
+
+	path := IdentityPath(pidFile)
+	tmp := path + ".tmp"
+
+	if err := ioutil.WriteFile(tmp, content, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+// ReadIdentity returns the identity recorded next to pidFile.
+func ReadIdentity(pidFile string) (Identity, error) {
+	var identity Identity
+
+	content, err := ioutil.ReadFile(IdentityPath(pidFile))
+
+	if err != nil {
+		return identity, err
+	}
+
+	err = json.Unmarshal(content, &identity)
+
+	if err != nil {
+		return identity, fmt.Errorf("failed to parse identity: %w", err)
+	}
+
+	return identity, nil
+}
+
+// Verify checks that pid is the process identified by identity, running for
+// entity the command argv, which is not checked if nil.
+func (i Identity) Verify(pid int, entity string, argv []string) error {
+	if i.PID != pid {
+		return fmt.Errorf("%w: PID %d recorded instead of %d", ErrIdentityMismatch, i.PID, pid)
+	}
+
+	if i.Entity != entity {
+		return fmt.Errorf("%w: it runs %s", ErrIdentityMismatch, i.Entity)
+	}
+
+	if argv != nil && i.ArgvHash != ArgvHash(argv) {
+		return fmt.Errorf("%w: it runs another command", ErrIdentityMismatch)
+	}
+
+	startTime, err := StartTime(pid)
+
+	if err != nil || startTime != i.StartTime {
+		return fmt.Errorf("%w: process %d was started after it", ErrIdentityMismatch, pid)
+	}
+
+	return nil
+}
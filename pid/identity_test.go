@@ -0,0 +1,63 @@
+package pid
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type IdentityTestSuite struct {
+	suite.Suite
+	pidFile string
+}
+
+func (suite *IdentityTestSuite) SetupTest() {
+	suite.pidFile = filepath.Join(suite.T().TempDir(), "command.pid")
+}
+
+func (suite *IdentityTestSuite) TestVerify() {
+	argv := []string{"sh", "-c", "sleep 1"}
+
+	identity, err := CurrentIdentity("shell.test", argv)
+	suite.Nil(err)
+	suite.Nil(WriteIdentity(suite.pidFile, identity))
+
+	identity, err = ReadIdentity(suite.pidFile)
+	suite.Nil(err)
+	suite.Equal(os.Getpid(), identity.PID)
+
+	suite.Nil(identity.Verify(os.Getpid(), "shell.test", nil))
+	suite.Nil(identity.Verify(os.Getpid(), "shell.test", argv))
+
+	err = identity.Verify(os.Getpid(), "shell.other", nil)
+	suite.True(errors.Is(err, ErrIdentityMismatch))
+
+	err = identity.Verify(os.Getpid(), "shell.test", []string{"sh", "-c", "sleep 2"})
+	suite.True(errors.Is(err, ErrIdentityMismatch))
+
+	err = identity.Verify(os.Getppid(), "shell.test", nil)
+	suite.True(errors.Is(err, ErrIdentityMismatch))
+}
+
+func (suite *IdentityTestSuite) TestReusedPID() {
+	identity, err := CurrentIdentity("shell.test", nil)
+	suite.Nil(err)
+
+	// same PID, started at another time
+	identity.StartTime--
+
+	err = identity.Verify(os.Getpid(), "shell.test", nil)
+	suite.True(errors.Is(err, ErrIdentityMismatch))
+}
+
+func (suite *IdentityTestSuite) TestArgvHash() {
+	suite.NotEqual(ArgvHash([]string{"a b"}), ArgvHash([]string{"a", "b"}))
+	suite.Equal(ArgvHash([]string{"a", "b"}), ArgvHash([]string{"a", "b"}))
+}
+
+func TestIdentityTestSuite(t *testing.T) {
+	suite.Run(t, new(IdentityTestSuite))
+}
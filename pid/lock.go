@@ -0,0 +1,153 @@
+package pid
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// ErrLocked is returned when the PID file is locked by another process.
+var ErrLocked = errors.New("PID file is locked by another process")
+
+// Lock is an exclusive lock on a PID file, held by the process running a
+// command for as long as it runs. Unlike the PID it contains, the lock
+// cannot outlive the process, which tells running commands from stale PID
+// files.
+type Lock struct {
+	file *os.File
+	path string
+}
+
+// Acquire locks the PID file at path, waiting for the process holding it to
+// exit if wait is true and failing with ErrLocked otherwise.
+func Acquire(path string, wait bool) (*Lock, error) {
+	for {
+		file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+
+		if err != nil {
+			return nil, err
+		}
+
+		how := syscall.LOCK_EX
+
+		if !wait {
+			how |= syscall.LOCK_NB
+		}
+
+		err = flock(file, how)
+
+		if err == syscall.EWOULDBLOCK {
+			file.Close()
+			return nil, ErrLocked
+		}
+
+		if err != nil {
+			file.Close()
+			return nil, fmt.Errorf("failed to lock PID file: %w", err)
+		}
+
+		// the previous holder may have removed the file while we were
+		// waiting, in which case the lock protects nothing
+		if sameFile(file, path) {
+			return &Lock{file: file, path: path}, nil
+		}
+
+		file.Close()
+	}
+}
+
+// WritePid writes the PID of the current process to the locked file.
+func (l *Lock) WritePid() error {
+	err := l.file.Truncate(0)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = l.file.WriteAt([]byte(strconv.Itoa(os.Getpid())), 0)
+
+	return err
+}
+
+// Release removes the PID file and unlocks it.
+func (l *Lock) Release() error {
+	err := os.Remove(l.path)
+
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		l.file.Close()
+		return err
+	}
+
+	return l.file.Close()
+}
+
+// Holder returns the PID of the process holding the lock on the PID file at
+// path, 0 if the file is missing or stale.
+func Holder(path string) (int, error) {
+	file, err := os.Open(path)
+
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	defer file.Close()
+
+	err = flock(file, syscall.LOCK_SH|syscall.LOCK_NB)
+
+	if err == nil {
+		return 0, nil
+	}
+
+	if err != syscall.EWOULDBLOCK {
+		return 0, fmt.Errorf("failed to check PID file lock: %w", err)
+	}
+
+	content, err := ioutil.ReadAll(file)
+
+	if err != nil {
+		return 0, err
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(string(content)))
+
+	if err != nil || !Alive(pid) {
+		// the holder did not write its PID yet
+		return 0, fmt.Errorf("%w, without a valid PID", ErrLocked)
+	}
+
+	return pid, nil
+}
+
+func flock(file *os.File, how int) error {
+	for {
+		err := syscall.Flock(int(file.Fd()), how)
+
+		if err != syscall.EINTR {
+			return err
+		}
+	}
+}
+
+func sameFile(file *os.File, path string) bool {
+	opened, err := file.Stat()
+
+	if err != nil {
+		return false
+	}
+
+	current, err := os.Stat(path)
+
+	if err != nil {
+		return false
+	}
+
+	return os.SameFile(opened, current)
+}
@@ -0,0 +1,81 @@
+package pid
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type LockTestSuite struct {
+	suite.Suite
+	path string
+}
+
+func (suite *LockTestSuite) SetupTest() {
+	suite.path = filepath.Join(suite.T().TempDir(), "command.pid")
+}
+
+func (suite *LockTestSuite) TestAcquire() {
+	holder, err := Holder(suite.path)
+	suite.Nil(err)
+	suite.Equal(0, holder)
+
+	lock, err := Acquire(suite.path, false)
+	suite.Nil(err)
+	suite.Nil(lock.WritePid())
+
+	holder, err = Holder(suite.path)
+	suite.Nil(err)
+	suite.Equal(os.Getpid(), holder)
+
+	_, err = Acquire(suite.path, false)
+	suite.ErrorIs(err, ErrLocked)
+
+	suite.Nil(lock.Release())
+	suite.NoFileExists(suite.path)
+}
+
+func (suite *LockTestSuite) TestStalePIDFile() {
+	suite.Nil(ioutil.WriteFile(suite.path, []byte("1"), 0644))
+
+	holder, err := Holder(suite.path)
+	suite.Nil(err)
+	suite.Equal(0, holder)
+
+	lock, err := Acquire(suite.path, false)
+	suite.Nil(err)
+	suite.Nil(lock.Release())
+}
+
+func (suite *LockTestSuite) TestWait() {
+	lock, err := Acquire(suite.path, false)
+	suite.Nil(err)
+
+	acquired := make(chan *Lock)
+
+	go func() {
+		next, err := Acquire(suite.path, true)
+		suite.Nil(err)
+		acquired <- next
+	}()
+
+	select {
+	case <-acquired:
+		suite.Fail("lock acquired while held")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	suite.Nil(lock.Release())
+
+	next := <-acquired
+	suite.FileExists(suite.path)
+	suite.Nil(next.Release())
+}
+
+func TestLockTestSuite(t *testing.T) {
+	suite.Run(t, new(LockTestSuite))
+}
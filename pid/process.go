@@ -0,0 +1,126 @@
+package pid
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// stat returns the fields of /proc/<pid>/stat following the command name.
+func stat(pid int) ([]string, error) {
+	content, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+
+	if err != nil {
+		return nil, err
+	}
+
+	end := strings.LastIndex(string(content), ")")
+
+	if end < 0 {
+		return nil, fmt.Errorf("malformed stat for process %d", pid)
+	}
+
+	return strings.Fields(string(content[end+1:])), nil
+}
+
+// Alive reports whether the process is running, zombies being considered
+// dead.
+func Alive(pid int) bool {
+	fields, err := stat(pid)
+
+	if err != nil {
+		return syscall.Kill(pid, 0) == nil
+	}
+
+	return len(fields) > 0 && fields[0] != "Z"
+}
+
+// KillSession kills every process of the session led by sid, which is how
+// the daemon and the command it runs are grouped.
+func KillSession(sid int) error {
+	return eachProcess(func(pid int, fields []string) error {
+		if len(fields) < 4 || fields[3] != strconv.Itoa(sid) {
+			return nil
+		}
+
+		return kill(pid)
+	})
+}
+
+// KillChildGroups kills the process groups of the children of parent but its
+// own, a run in the foreground starting its command in a process group of its
+// own.
+func KillChildGroups(parent int) error {
+	parentFields, err := stat(parent)
+
+	// its children are reparented once it exited
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	if err != nil || len(parentFields) < 3 {
+		return fmt.Errorf("failed to read process %d: %w", parent, err)
+	}
+
+	return eachProcess(func(pid int, fields []string) error {
+		if len(fields) < 3 || fields[1] != strconv.Itoa(parent) || fields[2] == parentFields[2] {
+			return nil
+		}
+
+		pgid, err := strconv.Atoi(fields[2])
+
+		if err != nil {
+			return nil
+		}
+
+		return kill(-pgid)
+	})
+}
+
+// eachProcess calls fn with the PID and the stat fields of every process.
+func eachProcess(fn func(pid int, fields []string) error) error {
+	entries, err := filepath.Glob("/proc/[0-9]*")
+
+	if err != nil {
+		return fmt.Errorf("failed to list processes: %w", err)
+	}
+
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(filepath.Base(entry))
+
+		if err != nil {
+			continue
+		}
+
+		fields, err := stat(pid)
+
+		if err != nil {
+			continue
+		}
+
+		err = fn(pid, fields)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// kill sends SIGKILL to pid, which may be a negated process group, ignoring
+// processes that are already gone.
+func kill(pid int) error {
+	err := syscall.Kill(pid, syscall.SIGKILL)
+
+	if err != nil && err != syscall.ESRCH {
+		return fmt.Errorf("failed to kill process %d: %w", pid, err)
+	}
+
+	return nil
+}
@@ -1,9 +1,11 @@
 package pid
 
 import (
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 
 	"golang.org/x/sys/unix"
 )
@@ -27,3 +29,26 @@ func ValidatePIDFile(pidfile string) error {
 
 	return nil
 }
+
+// ValidatePIDFileLocation checks that a PID file can be written at pidfile,
+// without creating it: the PID file of a run that is starting must not be
+// touched.
+func ValidatePIDFileLocation(pidfile string) error {
+	if pidfile == "" {
+		return errors.New("PID file path is empty")
+	}
+
+	if _, err := os.Stat(pidfile); err == nil {
+		err = unix.Access(pidfile, unix.W_OK|unix.R_OK)
+		if err != nil {
+			return fmt.Errorf("existing PID file is not readable/writable: %w", err)
+		}
+		return nil
+	}
+
+	if err := unix.Access(filepath.Dir(pidfile), unix.W_OK|unix.X_OK); err != nil {
+		return fmt.Errorf("PID file directory is not writable: %w", err)
+	}
+
+	return nil
+}
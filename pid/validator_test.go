@@ -0,0 +1,40 @@
+package pid
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type ValidatorTestSuite struct {
+	suite.Suite
+	dir string
+}
+
+func (suite *ValidatorTestSuite) SetupTest() {
+	suite.dir = suite.T().TempDir()
+}
+
+func (suite *ValidatorTestSuite) TestValidatePIDFileLocation() {
+	path := filepath.Join(suite.dir, "command.pid")
+
+	suite.Nil(ValidatePIDFileLocation(path))
+	suite.NoFileExists(path)
+
+	suite.Error(ValidatePIDFileLocation(""))
+	suite.Error(ValidatePIDFileLocation(filepath.Join(suite.dir, "missing", "command.pid")))
+}
+
+func (suite *ValidatorTestSuite) TestValidatePIDFileLocationKeepsExistingFile() {
+	path := filepath.Join(suite.dir, "command.pid")
+	suite.Nil(ioutil.WriteFile(path, []byte("1\n"), 0644))
+
+	suite.Nil(ValidatePIDFileLocation(path))
+	suite.FileExists(path)
+}
+
+func TestValidatorTestSuite(t *testing.T) {
+	suite.Run(t, new(ValidatorTestSuite))
+}
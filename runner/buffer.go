@@ -0,0 +1,221 @@
+package runner
+
+import (
+	"fmt"
+	"strings"
+)
+
+type RetentionMode string
+
+const (
+	KeepHead     RetentionMode = "head"
+	KeepTail     RetentionMode = "tail"
+	KeepHeadTail RetentionMode = "head_tail"
+)
+
+const DefaultElisionMarker = "[...]\n"
+
+type OutputPolicy struct {
+	MaxBytes      int
+	MaxLines      int
+	Mode          RetentionMode
+	ElisionMarker string
+}
+
+func ParseRetentionMode(mode string) (RetentionMode, error) {
+	switch RetentionMode(mode) {
+	case KeepHead, KeepTail, KeepHeadTail:
+		return RetentionMode(mode), nil
+	}
+
+	return "", fmt.Errorf(
+		"invalid retention mode %q (expected %s, %s or %s)",
+		mode,
+		KeepHead,
+		KeepTail,
+		KeepHeadTail,
+	)
+}
+
+func (p OutputPolicy) unbounded() bool {
+	return p.MaxBytes <= 0 && p.MaxLines <= 0
+}
+
+// OutputBuffer retains command output according to an OutputPolicy, keeping
+// the first lines, the last lines or both and counting what was dropped.
+type OutputBuffer struct {
+	policy       OutputPolicy
+	head         lineQueue
+	tail         lineQueue
+	droppedBytes int
+	droppedLines int
+}
+
+func NewOutputBuffer(policy OutputPolicy) *OutputBuffer {
+	if policy.Mode == "" {
+		policy.Mode = KeepTail
+	}
+
+	if policy.ElisionMarker == "" {
+		policy.ElisionMarker = DefaultElisionMarker
+	}
+
+	buffer := &OutputBuffer{
+		policy: policy,
+	}
+
+	headBytes, tailBytes := split(policy.Mode, policy.MaxBytes)
+	headLines, tailLines := split(policy.Mode, policy.MaxLines)
+
+	buffer.head = lineQueue{maxBytes: headBytes, maxLines: headLines}
+	buffer.tail = lineQueue{maxBytes: tailBytes, maxLines: tailLines}
+
+	return buffer
+}
+
+// split distributes a limit between the head and the tail of the buffer, a
+// negative value meaning unlimited.
+func split(mode RetentionMode, limit int) (int, int) {
+	if limit <= 0 {
+		return -1, -1
+	}
+
+	switch mode {
+	case KeepHead:
+		return limit, 0
+	case KeepHeadTail:
+		return limit / 2, limit - limit/2
+	}
+
+	return 0, limit
+}
+
+func (b *OutputBuffer) Append(text string) {
+	b.AppendLine(OutputLine{Text: text})
+}
+
+func (b *OutputBuffer) AppendLine(line OutputLine) {
+	if b.policy.unbounded() {
+		b.head.push(line)
+		return
+	}
+
+	if b.policy.Mode == KeepHead {
+		if b.droppedLines == 0 && b.head.fits(line) {
+			b.head.push(line)
+		} else {
+			b.drop(line)
+		}
+		return
+	}
+
+	if b.policy.Mode == KeepHeadTail && b.tail.empty() && b.head.fits(line) {
+		b.head.push(line)
+		return
+	}
+
+	if b.tail.maxBytes >= 0 && len(line.Text) > b.tail.maxBytes {
+		cut := len(line.Text) - b.tail.maxBytes
+		b.droppedBytes += cut
+		line.Text = line.Text[cut:]
+	}
+
+	b.tail.push(line)
+
+	for b.tail.overflows() {
+		b.drop(b.tail.pop())
+	}
+}
+
+func (b *OutputBuffer) drop(line OutputLine) {
+	b.droppedBytes += len(line.Text)
+	b.droppedLines++
+}
+
+func (b *OutputBuffer) String() string {
+	var builder strings.Builder
+
+	builder.WriteString(b.head.String())
+
+	if b.droppedBytes > 0 {
+		builder.WriteString(b.policy.ElisionMarker)
+	}
+
+	builder.WriteString(b.tail.String())
+
+	return builder.String()
+}
+
+// Lines returns the retained lines.
+func (b *OutputBuffer) Lines() []OutputLine {
+	lines := make([]OutputLine, 0, len(b.head.lines)+len(b.tail.lines))
+	lines = append(lines, b.head.lines...)
+
+	return append(lines, b.tail.lines...)
+}
+
+func (b *OutputBuffer) DroppedBytes() int {
+	return b.droppedBytes
+}
+
+func (b *OutputBuffer) DroppedLines() int {
+	return b.droppedLines
+}
+
+func (b *OutputBuffer) Reset() {
+	*b = *NewOutputBuffer(b.policy)
+}
+
+type lineQueue struct {
+	lines    []OutputLine
+	bytes    int
+	maxBytes int
+	maxLines int
+}
+
+func (q *lineQueue) push(line OutputLine) {
+	q.lines = append(q.lines, line)
+	q.bytes += len(line.Text)
+}
+
+func (q *lineQueue) pop() OutputLine {
+	line := q.lines[0]
+	q.lines = q.lines[1:]
+	q.bytes -= len(line.Text)
+
+	return line
+}
+
+func (q *lineQueue) empty() bool {
+	return len(q.lines) == 0
+}
+
+func (q *lineQueue) fits(line OutputLine) bool {
+	if q.maxBytes >= 0 && q.bytes+len(line.Text) > q.maxBytes {
+		return false
+	}
+
+	return q.maxLines < 0 || len(q.lines) < q.maxLines
+}
+
+func (q *lineQueue) overflows() bool {
+	if q.empty() {
+		return false
+	}
+
+	if q.maxBytes >= 0 && q.bytes > q.maxBytes {
+		return true
+	}
+
+	return q.maxLines >= 0 && len(q.lines) > q.maxLines
+}
+
+func (q *lineQueue) String() string {
+	var builder strings.Builder
+
+	for _, line := range q.lines {
+		builder.WriteString(line.Text)
+	}
+
+	return builder.String()
+}
@@ -0,0 +1,114 @@
+package runner
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type OutputBufferTestSuite struct {
+	suite.Suite
+}
+
+func (suite *OutputBufferTestSuite) append(buffer *OutputBuffer, lines ...string) {
+	for _, line := range lines {
+		buffer.Append(line)
+	}
+}
+
+func (suite *OutputBufferTestSuite) TestUnbounded() {
+	buffer := NewOutputBuffer(OutputPolicy{})
+	suite.append(buffer, "a\n", "b\n", "c\n")
+
+	suite.Equal("a\nb\nc\n", buffer.String())
+	suite.Equal(0, buffer.DroppedBytes())
+}
+
+func (suite *OutputBufferTestSuite) TestKeepHead() {
+	buffer := NewOutputBuffer(OutputPolicy{MaxLines: 2, Mode: KeepHead})
+	suite.append(buffer, "a\n", "b\n", "c\n", "d\n")
+
+	suite.Equal("a\nb\n[...]\n", buffer.String())
+	suite.Equal(4, buffer.DroppedBytes())
+	suite.Equal(2, buffer.DroppedLines())
+}
+
+func (suite *OutputBufferTestSuite) TestKeepHeadStopsAtFirstDrop() {
+	buffer := NewOutputBuffer(OutputPolicy{MaxBytes: 4, Mode: KeepHead})
+	suite.append(buffer, "a\n", "long\n", "b\n")
+
+	suite.Equal("a\n[...]\n", buffer.String())
+	suite.Equal(7, buffer.DroppedBytes())
+}
+
+func (suite *OutputBufferTestSuite) TestKeepTail() {
+	buffer := NewOutputBuffer(OutputPolicy{MaxBytes: 4, Mode: KeepTail})
+	suite.append(buffer, "a\n", "b\n", "c\n")
+
+	suite.Equal("[...]\nb\nc\n", buffer.String())
+	suite.Equal(2, buffer.DroppedBytes())
+	suite.Equal(1, buffer.DroppedLines())
+}
+
+func (suite *OutputBufferTestSuite) TestKeepTailTruncatesLongLine() {
+	buffer := NewOutputBuffer(OutputPolicy{MaxBytes: 4, Mode: KeepTail})
+	suite.append(buffer, "abcdef\n")
+
+	suite.Equal("[...]\ndef\n", buffer.String())
+	suite.Equal(3, buffer.DroppedBytes())
+}
+
+func (suite *OutputBufferTestSuite) TestKeepHeadTail() {
+	buffer := NewOutputBuffer(OutputPolicy{
+		MaxLines:      4,
+		Mode:          KeepHeadTail,
+		ElisionMarker: "--\n",
+	})
+	suite.append(buffer, "1\n", "2\n", "3\n", "4\n", "5\n", "6\n")
+
+	suite.Equal("1\n2\n--\n5\n6\n", buffer.String())
+	suite.Equal(4, buffer.DroppedBytes())
+	suite.Equal(2, buffer.DroppedLines())
+}
+
+func (suite *OutputBufferTestSuite) TestKeepHeadTailWithoutDrop() {
+	buffer := NewOutputBuffer(OutputPolicy{MaxLines: 4, Mode: KeepHeadTail})
+	suite.append(buffer, "1\n", "2\n", "3\n")
+
+	suite.Equal("1\n2\n3\n", buffer.String())
+	suite.Equal(0, buffer.DroppedBytes())
+}
+
+func (suite *OutputBufferTestSuite) TestLines() {
+	buffer := NewOutputBuffer(OutputPolicy{MaxLines: 2, Mode: KeepHeadTail})
+	buffer.AppendLine(OutputLine{Stream: StreamStdout, Text: "1\n"})
+	buffer.AppendLine(OutputLine{Stream: StreamStderr, Text: "2\n"})
+	buffer.AppendLine(OutputLine{Stream: StreamStdout, Text: "3\n"})
+
+	suite.Equal([]OutputLine{
+		{Stream: StreamStdout, Text: "1\n"},
+		{Stream: StreamStdout, Text: "3\n"},
+	}, buffer.Lines())
+}
+
+func (suite *OutputBufferTestSuite) TestReset() {
+	buffer := NewOutputBuffer(OutputPolicy{MaxLines: 1})
+	suite.append(buffer, "a\n", "b\n")
+	buffer.Reset()
+
+	suite.Equal("", buffer.String())
+	suite.Equal(0, buffer.DroppedBytes())
+}
+
+func (suite *OutputBufferTestSuite) TestParseRetentionMode() {
+	mode, err := ParseRetentionMode("head_tail")
+	suite.Nil(err)
+	suite.Equal(KeepHeadTail, mode)
+
+	_, err = ParseRetentionMode("middle")
+	suite.NotNil(err)
+}
+
+func TestOutputBufferTestSuite(t *testing.T) {
+	suite.Run(t, new(OutputBufferTestSuite))
+}
@@ -2,23 +2,66 @@ package runner
 
 import (
 	"fmt"
+	"os"
+	"syscall"
 )
 
 type Command struct {
-	bin  string
-	args []string
+	bin        string
+	args       []string
+	dir        string
+	env        []string
+	clearEnv   bool
+	credential *syscall.Credential
 }
 
-func NewCommand(args []string) (Command, error) {
+type CommandOption func(c *Command)
+
+// WithDir runs the command in dir instead of the current directory.
+func WithDir(dir string) CommandOption {
+	return func(c *Command) {
+		c.dir = dir
+	}
+}
+
+// WithEnv sets environment variables given as KEY=VALUE, later ones taking
+// precedence.
+func WithEnv(env ...string) CommandOption {
+	return func(c *Command) {
+		c.env = append(c.env, env...)
+	}
+}
+
+// WithClearEnv does not pass the environment of hass-run to the command.
+func WithClearEnv() CommandOption {
+	return func(c *Command) {
+		c.clearEnv = true
+	}
+}
+
+// WithCredential runs the command as another user and group.
+func WithCredential(credential *syscall.Credential) CommandOption {
+	return func(c *Command) {
+		c.credential = credential
+	}
+}
+
+func NewCommand(args []string, options ...CommandOption) (Command, error) {
 
 	if len(args) == 0 {
 		return Command{}, fmt.Errorf("empty command")
 	}
 
-	return Command{
+	command := Command{
 		bin:  args[0],
 		args: args[1:],
-	}, nil
+	}
+
+	for _, option := range options {
+		option(&command)
+	}
+
+	return command, nil
 }
 
 func (c Command) Bin() string {
@@ -28,3 +71,27 @@ func (c Command) Bin() string {
 func (c Command) Args() []string {
 	return c.args
 }
+
+func (c Command) Dir() string {
+	return c.dir
+}
+
+// Env returns the environment of the command, nil meaning the one of
+// hass-run.
+func (c Command) Env() []string {
+	if !c.clearEnv && len(c.env) == 0 {
+		return nil
+	}
+
+	env := []string{}
+
+	if !c.clearEnv {
+		env = append(env, os.Environ()...)
+	}
+
+	return append(env, c.env...)
+}
+
+func (c Command) Credential() *syscall.Credential {
+	return c.credential
+}
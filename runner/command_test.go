@@ -30,6 +30,35 @@ func (suite *CommandTestSuite) TestEmptyCommand() {
 
 }
 
+func (suite *CommandTestSuite) TestEnv() {
+	command, err := NewCommand([]string{"ls"})
+	suite.Nil(err)
+	suite.Nil(command.Env())
+
+	command, err = NewCommand([]string{"ls"}, WithEnv("FOO=foo"))
+	suite.Nil(err)
+	suite.Contains(command.Env(), "FOO=foo")
+	suite.Greater(len(command.Env()), 1)
+
+	command, err = NewCommand([]string{"ls"}, WithClearEnv())
+	suite.Nil(err)
+	suite.Equal([]string{}, command.Env())
+}
+
+func (suite *CommandTestSuite) TestLookupCredential() {
+	credential, err := LookupCredential("", "")
+	suite.Nil(err)
+	suite.Nil(credential)
+
+	credential, err = LookupCredential("root", "0")
+	suite.Nil(err)
+	suite.Equal(uint32(0), credential.Uid)
+	suite.Equal(uint32(0), credential.Gid)
+
+	_, err = LookupCredential("no-such-user", "")
+	suite.NotNil(err)
+}
+
 func TestCommandTestSuite(t *testing.T) {
 	suite.Run(t, new(CommandTestSuite))
 }
@@ -0,0 +1,33 @@
+package runner
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// ControlPrefix marks the stdout lines through which the command sets custom
+// attributes, published in the extra attribute, instead of printing output:
+//
+//	::hass-run::{"phase": "upload", "files_copied": 1234}
+//
+// A null value removes the attribute.
+const ControlPrefix = "::hass-run::"
+
+// parseControlLine returns the attributes set by line, ok being false if it
+// is not a control line.
+func parseControlLine(line string) (map[string]interface{}, bool, error) {
+	if !strings.HasPrefix(line, ControlPrefix) {
+		return nil, false, nil
+	}
+
+	var attributes map[string]interface{}
+
+	err := json.Unmarshal([]byte(strings.TrimPrefix(line, ControlPrefix)), &attributes)
+
+	if err != nil {
+		return nil, true, fmt.Errorf("invalid control line: %w", err)
+	}
+
+	return attributes, true, nil
+}
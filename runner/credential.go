@@ -0,0 +1,89 @@
+package runner
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"syscall"
+)
+
+// LookupCredential resolves the user and group the command runs as, given by
+// name or id. The group defaults to the primary group of the user and the
+// user to the current one. It returns nil if neither is given.
+func LookupCredential(userName string, groupName string) (*syscall.Credential, error) {
+	if userName == "" && groupName == "" {
+		return nil, nil
+	}
+
+	credential := &syscall.Credential{
+		Uid: uint32(os.Getuid()),
+		Gid: uint32(os.Getgid()),
+		// only root may set the supplementary groups
+		NoSetGroups: os.Getuid() != 0,
+	}
+
+	if userName != "" {
+		account, err := user.Lookup(userName)
+
+		if err != nil {
+			account, err = user.LookupId(userName)
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("unknown user %q", userName)
+		}
+
+		credential.Uid, err = parseID(account.Uid)
+
+		if err != nil {
+			return nil, err
+		}
+
+		credential.Gid, err = parseID(account.Gid)
+
+		if err != nil {
+			return nil, err
+		}
+
+		groupIds, err := account.GroupIds()
+
+		if err == nil {
+			for _, groupId := range groupIds {
+				if gid, err := parseID(groupId); err == nil {
+					credential.Groups = append(credential.Groups, gid)
+				}
+			}
+		}
+	}
+
+	if groupName != "" {
+		group, err := user.LookupGroup(groupName)
+
+		if err != nil {
+			group, err = user.LookupGroupId(groupName)
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("unknown group %q", groupName)
+		}
+
+		credential.Gid, err = parseID(group.Gid)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return credential, nil
+}
+
+func parseID(id string) (uint32, error) {
+	value, err := strconv.ParseUint(id, 10, 32)
+
+	if err != nil {
+		return 0, fmt.Errorf("invalid id %q", id)
+	}
+
+	return uint32(value), nil
+}
@@ -0,0 +1,90 @@
+package runner
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ReadEnvFile reads KEY=VALUE lines from path, skipping empty lines and
+// comments. Lines may start with "export" and values may be quoted.
+func ReadEnvFile(path string) ([]string, error) {
+	file, err := os.Open(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	env := []string{}
+	scanner := bufio.NewScanner(file)
+	lineNumber := 0
+
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		line = strings.TrimPrefix(line, "export ")
+		key, value, err := parseEnvLine(line)
+
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, lineNumber, err)
+		}
+
+		env = append(env, key+"="+value)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return env, nil
+}
+
+func parseEnvLine(line string) (string, string, error) {
+	separator := strings.Index(line, "=")
+
+	if separator < 0 {
+		return "", "", fmt.Errorf("expected KEY=VALUE, got %q", line)
+	}
+
+	key := strings.TrimSpace(line[:separator])
+	value := strings.TrimSpace(line[separator+1:])
+
+	if key == "" || strings.ContainsAny(key, " \t") {
+		return "", "", fmt.Errorf("invalid variable name %q", key)
+	}
+
+	switch {
+	case len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"':
+		unquoted, err := strconv.Unquote(value)
+
+		if err != nil {
+			return "", "", fmt.Errorf("invalid value of %s: %w", key, err)
+		}
+
+		value = unquoted
+	case len(value) >= 2 && value[0] == '\'' && value[len(value)-1] == '\'':
+		value = value[1 : len(value)-1]
+	}
+
+	return key, value, nil
+}
+
+// ValidateEnv checks that variables are given as KEY=VALUE.
+func ValidateEnv(env []string) error {
+	for _, variable := range env {
+		if strings.Index(variable, "=") <= 0 {
+			return fmt.Errorf("expected KEY=VALUE, got %q", variable)
+		}
+	}
+
+	return nil
+}
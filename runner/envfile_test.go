@@ -0,0 +1,63 @@
+package runner
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type EnvFileTestSuite struct {
+	suite.Suite
+}
+
+func (suite *EnvFileTestSuite) write(content string) string {
+	path := filepath.Join(suite.T().TempDir(), ".env")
+	suite.Nil(ioutil.WriteFile(path, []byte(content), 0600))
+
+	return path
+}
+
+func (suite *EnvFileTestSuite) TestReadEnvFile() {
+	path := suite.write(`
+# comment
+FOO=foo
+export BAR = bar
+QUOTED="a \"b\"\nc"
+SINGLE='$HOME'
+EMPTY=
+URL=https://host/?a=b
+`)
+
+	env, err := ReadEnvFile(path)
+	suite.Nil(err)
+	suite.Equal([]string{
+		"FOO=foo",
+		"BAR=bar",
+		"QUOTED=a \"b\"\nc",
+		"SINGLE=$HOME",
+		"EMPTY=",
+		"URL=https://host/?a=b",
+	}, env)
+}
+
+func (suite *EnvFileTestSuite) TestInvalidLine() {
+	_, err := ReadEnvFile(suite.write("FOO=foo\nBAR\n"))
+	suite.ErrorContains(err, ".env:2")
+}
+
+func (suite *EnvFileTestSuite) TestMissingFile() {
+	_, err := ReadEnvFile(filepath.Join(suite.T().TempDir(), "missing"))
+	suite.NotNil(err)
+}
+
+func (suite *EnvFileTestSuite) TestValidateEnv() {
+	suite.Nil(ValidateEnv([]string{"FOO=foo", "BAR="}))
+	suite.NotNil(ValidateEnv([]string{"FOO"}))
+	suite.NotNil(ValidateEnv([]string{"=foo"}))
+}
+
+func TestEnvFileTestSuite(t *testing.T) {
+	suite.Run(t, new(EnvFileTestSuite))
+}
@@ -0,0 +1,130 @@
+package runner
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// Events fired on the event bus of Home-Assistant, their type being prefixed
+// with EventTypePrefix.
+const (
+	EventStarted  = "started"
+	EventFinished = "finished"
+	EventFailed   = "failed"
+	EventOutput   = "output"
+)
+
+const EventTypePrefix = "hass_run_"
+
+const DefaultEventOutputLines = 10
+
+// eventQueueSize is the number of events waiting to be fired, beyond which
+// output events are dropped rather than slowing the command down.
+const eventQueueSize = 256
+
+// EventBus fires Home-Assistant events about an entity.
+type EventBus interface {
+	Entity() string
+	FireEvent(eventType string, data interface{}) error
+}
+
+func ValidateEvents(events []string) error {
+	for _, event := range events {
+		switch event {
+		case EventStarted, EventFinished, EventFailed, EventOutput:
+			continue
+		}
+
+		return fmt.Errorf(
+			"unknown event %q (expected %s, %s, %s or %s)",
+			event,
+			EventStarted,
+			EventFinished,
+			EventFailed,
+			EventOutput,
+		)
+	}
+
+	return nil
+}
+
+type event struct {
+	eventType string
+	data      map[string]interface{}
+}
+
+// eventQueue fires events in order, without making the runner wait on
+// Home-Assistant.
+type eventQueue struct {
+	bus    EventBus
+	events chan event
+	done   chan struct{}
+}
+
+func newEventQueue(bus EventBus) *eventQueue {
+	queue := &eventQueue{
+		bus:    bus,
+		events: make(chan event, eventQueueSize),
+		done:   make(chan struct{}),
+	}
+
+	go queue.loop()
+
+	return queue
+}
+
+func (q *eventQueue) loop() {
+	defer close(q.done)
+
+	for event := range q.events {
+		err := q.bus.FireEvent(event.eventType, event.data)
+
+		if err != nil {
+			log.Printf("Failed to fire %s event: %s", event.eventType, err.Error())
+		}
+	}
+}
+
+// fire queues an event, waiting for room in the queue unless drop is set.
+func (q *eventQueue) fire(name string, data map[string]interface{}, drop bool) {
+	data["entity_id"] = q.bus.Entity()
+
+	event := event{eventType: EventTypePrefix + name, data: data}
+
+	if !drop {
+		q.events <- event
+		return
+	}
+
+	select {
+	case q.events <- event:
+	default:
+		log.Printf("Dropping %s event, too many events are pending", event.eventType)
+	}
+}
+
+// close waits for the queued events to be fired.
+func (q *eventQueue) close() {
+	close(q.events)
+	<-q.done
+}
+
+// tailLines returns the last n lines of output, none if n is negative.
+func tailLines(output string, n int) string {
+	if n < 0 {
+		n = 0
+	}
+
+	lines := strings.SplitAfter(output, "\n")
+
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+
+	return strings.Join(lines, "")
+}
@@ -0,0 +1,33 @@
+package runner
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type EventsTestSuite struct {
+	suite.Suite
+}
+
+func (suite *EventsTestSuite) TestValidateEvents() {
+	suite.Nil(ValidateEvents([]string{EventStarted, EventFinished, EventFailed, EventOutput}))
+	suite.Nil(ValidateEvents(nil))
+	suite.EqualError(
+		ValidateEvents([]string{EventStarted, "stopped"}),
+		`unknown event "stopped" (expected started, finished, failed or output)`,
+	)
+}
+
+func (suite *EventsTestSuite) TestTailLines() {
+	suite.Equal("b\nc\n", tailLines("a\nb\nc\n", 2))
+	suite.Equal("b\nc", tailLines("a\nb\nc", 2))
+	suite.Equal("a\n", tailLines("a\n", 2))
+	suite.Equal("", tailLines("a\nb\n", 0))
+	suite.Equal("", tailLines("a\nb\n", -1))
+	suite.Equal("", tailLines("", 2))
+}
+
+func TestEventsTestSuite(t *testing.T) {
+	suite.Run(t, new(EventsTestSuite))
+}
@@ -0,0 +1,223 @@
+package runner
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Outcomes of a run triggering hooks.
+const (
+	HookOnSuccess = "success"
+	HookOnFailure = "failure"
+)
+
+const DefaultHookOutputLines = 10
+
+// ServiceCaller calls Home-Assistant services on behalf of an entity.
+type ServiceCaller interface {
+	Entity() string
+	CallService(domain string, service string, data interface{}) error
+}
+
+// HookContext is what the templates of a hook are executed with.
+type HookContext struct {
+	Entity      string
+	State       string
+	ExitCode    int
+	Signal      string
+	CancelledBy string
+	Duration    time.Duration
+	StartedAt   time.Time
+	EndedAt     time.Time
+	Output      string
+	LogPath     string
+}
+
+// Hook calls a service once a run succeeded or failed, the strings of its
+// data being templates executed with a HookContext.
+type Hook struct {
+	on          string
+	domain      string
+	service     string
+	data        interface{}
+	outputLines int
+}
+
+// NewHook creates a hook calling service (e.g notify.mobile_app_phone) on
+// the outcome on, with the last outputLines lines of output.
+func NewHook(on string, service string, data map[string]interface{}, outputLines int) (*Hook, error) {
+	if on != HookOnSuccess && on != HookOnFailure {
+		return nil, fmt.Errorf("invalid hook outcome %q", on)
+	}
+
+	parts := strings.Split(service, ".")
+
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return nil, fmt.Errorf("invalid service %q (expected domain.service)", service)
+	}
+
+	compiled, err := compileTemplates(data)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &Hook{
+		on:          on,
+		domain:      parts[0],
+		service:     parts[1],
+		data:        compiled,
+		outputLines: outputLines,
+	}, nil
+}
+
+// compileTemplates returns value with its strings replaced by templates.
+func compileTemplates(value interface{}) (interface{}, error) {
+	switch value := value.(type) {
+	case string:
+		compiled, err := template.New("").Option("missingkey=error").Parse(value)
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid template: %w", err)
+		}
+
+		return compiled, nil
+	case map[string]interface{}:
+		compiled := make(map[string]interface{}, len(value))
+
+		for key, item := range value {
+			var err error
+
+			compiled[key], err = compileTemplates(item)
+
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		return compiled, nil
+	case map[interface{}]interface{}:
+		converted := make(map[string]interface{}, len(value))
+
+		for key, item := range value {
+			converted[fmt.Sprint(key)] = item
+		}
+
+		return compileTemplates(converted)
+	case []interface{}:
+		compiled := make([]interface{}, len(value))
+
+		for i, item := range value {
+			var err error
+
+			compiled[i], err = compileTemplates(item)
+
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		return compiled, nil
+	}
+
+	return value, nil
+}
+
+// render returns value with its templates executed with context.
+func render(value interface{}, context HookContext) (interface{}, error) {
+	switch value := value.(type) {
+	case *template.Template:
+		var buffer bytes.Buffer
+
+		err := value.Execute(&buffer, context)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return buffer.String(), nil
+	case map[string]interface{}:
+		rendered := make(map[string]interface{}, len(value))
+
+		for key, item := range value {
+			var err error
+
+			rendered[key], err = render(item, context)
+
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		return rendered, nil
+	case []interface{}:
+		rendered := make([]interface{}, len(value))
+
+		for i, item := range value {
+			var err error
+
+			rendered[i], err = render(item, context)
+
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		return rendered, nil
+	}
+
+	return value, nil
+}
+
+// Data returns the data of the service call for context.
+func (h *Hook) Data(context HookContext) (interface{}, error) {
+	context.Output = tailLines(context.Output, h.outputLines)
+
+	return render(h.data, context)
+}
+
+// callHooks calls the hooks matching the outcome of the run described by
+// payload, logging their failures.
+func (r *Runner) callHooks(payload Payload) {
+	on := HookOnFailure
+
+	if payload.State == StateSuccess {
+		on = HookOnSuccess
+	}
+
+	context := HookContext{
+		Entity:      r.serviceCaller.Entity(),
+		State:       payload.State,
+		ExitCode:    payload.Attributes.ExitCode,
+		Signal:      payload.Attributes.Signal,
+		CancelledBy: payload.Attributes.CancelledBy,
+		Duration:    payload.Attributes.EndedAt.Sub(payload.Attributes.StartedAt).Round(time.Second),
+		StartedAt:   payload.Attributes.StartedAt,
+		EndedAt:     payload.Attributes.EndedAt,
+		Output:      payload.Attributes.Output,
+		LogPath:     payload.Attributes.LogPath,
+	}
+
+	for _, hook := range r.hooks {
+		if hook.on != on {
+			continue
+		}
+
+		data, err := hook.Data(context)
+
+		if err != nil {
+			log.Printf("Failed to render %s.%s hook: %s", hook.domain, hook.service, err.Error())
+			continue
+		}
+
+		err = r.serviceCaller.CallService(hook.domain, hook.service, data)
+
+		if err != nil {
+			log.Printf("Failed to call %s.%s: %s", hook.domain, hook.service, err.Error())
+		}
+	}
+}
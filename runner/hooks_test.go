@@ -0,0 +1,64 @@
+package runner
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type HooksTestSuite struct {
+	suite.Suite
+}
+
+func (suite *HooksTestSuite) TestInvalidHooks() {
+	_, err := NewHook("done", "notify.phone", nil, 1)
+	suite.EqualError(err, `invalid hook outcome "done"`)
+
+	_, err = NewHook(HookOnFailure, "notify", nil, 1)
+	suite.EqualError(err, `invalid service "notify" (expected domain.service)`)
+
+	_, err = NewHook(HookOnFailure, "notify.phone", map[string]interface{}{"message": "{{.Entity"}, 1)
+	suite.ErrorContains(err, "invalid template")
+}
+
+func (suite *HooksTestSuite) TestData() {
+	hook, err := NewHook(
+		HookOnFailure,
+		"notify.phone",
+		map[string]interface{}{
+			"message": "{{.Entity}} failed after {{.Duration}}:\n{{.Output}}",
+			"targets": []interface{}{"{{.State}}", 3},
+			"nested":  map[interface{}]interface{}{"log": "{{.LogPath}}"},
+		},
+		2,
+	)
+	suite.Nil(err)
+
+	data, err := hook.Data(HookContext{
+		Entity:   "shell.backup",
+		State:    StateTimeout,
+		Duration: 90 * time.Second,
+		Output:   "a\nb\nc\n",
+		LogPath:  "/tmp/backup.log",
+	})
+	suite.Nil(err)
+
+	suite.Equal(map[string]interface{}{
+		"message": "shell.backup failed after 1m30s:\nb\nc\n",
+		"targets": []interface{}{StateTimeout, 3},
+		"nested":  map[string]interface{}{"log": "/tmp/backup.log"},
+	}, data)
+}
+
+func (suite *HooksTestSuite) TestUnknownField() {
+	hook, err := NewHook(HookOnFailure, "notify.phone", map[string]interface{}{"message": "{{.Nope}}"}, 1)
+	suite.Nil(err)
+
+	_, err = hook.Data(HookContext{})
+	suite.Error(err)
+}
+
+func TestHooksTestSuite(t *testing.T) {
+	suite.Run(t, new(HooksTestSuite))
+}
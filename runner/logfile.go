@@ -0,0 +1,251 @@
+package runner
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	StreamStdout = "stdout"
+	StreamStderr = "stderr"
+	StreamMeta   = "meta"
+)
+
+const (
+	logTruncatedMessage = "[log size limit reached, earlier output dropped]"
+	logDroppedMessage   = "[line exceeding the log size limit dropped]"
+	logStartedMessage   = "started"
+	logEndedMessage     = "ended"
+)
+
+// LogFile writes the whole output of a run to <dir>/<entity>.log, the logs
+// of previous runs being rotated to <entity>.log.1, <entity>.log.2... Each
+// line is prefixed by its timestamp and stream, the start and the end of the
+// run being recorded in the meta stream. Once a log reaches its maximum size,
+// its oldest half is dropped so that it keeps the tail of the output.
+type LogFile struct {
+	dir      string
+	entity   string
+	maxSize  int64
+	maxFiles int
+	mutex    sync.Mutex
+	file     *os.File
+	size     int64
+}
+
+func NewLogFile(dir string, entity string, maxSize int64, maxFiles int) *LogFile {
+	return &LogFile{
+		dir:      dir,
+		entity:   entity,
+		maxSize:  maxSize,
+		maxFiles: maxFiles,
+	}
+}
+
+func LogFilePath(dir string, entity string, run int) string {
+	path := filepath.Join(dir, entity+".log")
+
+	if run > 0 {
+		path = fmt.Sprintf("%s.%d", path, run)
+	}
+
+	return path
+}
+
+func (l *LogFile) Path() string {
+	return LogFilePath(l.dir, l.entity, 0)
+}
+
+// Open rotates the previous logs and starts a new log file.
+func (l *LogFile) Open() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if err := os.MkdirAll(l.dir, 0700); err != nil {
+		return fmt.Errorf("failed to create log directory: %w", err)
+	}
+
+	if err := l.rotate(); err != nil {
+		return err
+	}
+
+	// the log is read back when dropping its oldest lines, it is private
+	// and a link planted in its place is not followed
+	file, err := os.OpenFile(l.Path(), os.O_RDWR|os.O_CREATE|os.O_TRUNC|syscall.O_NOFOLLOW, 0600)
+
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+
+	l.file = file
+	l.size = 0
+
+	return l.write(StreamMeta, logStartedMessage+"\n")
+}
+
+func (l *LogFile) rotate() error {
+	if l.maxFiles <= 1 {
+		return nil
+	}
+
+	for run := l.maxFiles - 1; run > 0; run-- {
+		err := os.Rename(
+			LogFilePath(l.dir, l.entity, run-1),
+			LogFilePath(l.dir, l.entity, run),
+		)
+
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to rotate log files: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// Write appends line, which must end with a new line, to the log.
+func (l *LogFile) Write(stream string, line string) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.file == nil {
+		return nil
+	}
+
+	if l.maxSize > 0 && l.size+int64(len(formatLogLine(time.Now(), stream, line))) > l.maxSize {
+		err := l.dropHead(stream)
+
+		if err != nil {
+			return err
+		}
+
+		if l.size+int64(len(formatLogLine(time.Now(), stream, line))) > l.maxSize {
+			line = logDroppedMessage + "\n"
+		}
+	}
+
+	return l.write(stream, line)
+}
+
+// dropHead rewrites the log with its start line and its most recent lines,
+// which take at most half of the maximum size. The file is rewritten in place
+// so that it can still be followed.
+func (l *LogFile) dropHead(stream string) error {
+	content := make([]byte, l.size)
+
+	_, err := l.file.ReadAt(content, 0)
+
+	if err != nil {
+		return fmt.Errorf("failed to read log file: %w", err)
+	}
+
+	head := content[:bytes.IndexByte(content, '\n')+1]
+	tail := content[len(head):]
+
+	if keep := int(l.maxSize / 2); len(tail) > keep {
+		tail = tail[len(tail)-keep:]
+		// the first line kept is likely partial
+		tail = tail[bytes.IndexByte(tail, '\n')+1:]
+	}
+
+	rewritten := make([]byte, 0, len(content))
+	rewritten = append(rewritten, head...)
+	rewritten = append(rewritten, formatLogLine(time.Now(), stream, logTruncatedMessage+"\n")...)
+	rewritten = append(rewritten, tail...)
+
+	_, err = l.file.WriteAt(rewritten, 0)
+
+	if err == nil {
+		err = l.file.Truncate(int64(len(rewritten)))
+	}
+
+	if err == nil {
+		_, err = l.file.Seek(int64(len(rewritten)), io.SeekStart)
+	}
+
+	if err != nil {
+		return fmt.Errorf("failed to write log file: %w", err)
+	}
+
+	l.size = int64(len(rewritten))
+
+	return nil
+}
+
+// End records the final state of the run, even if the log is full.
+func (l *LogFile) End(state string) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.file == nil {
+		return nil
+	}
+
+	return l.write(StreamMeta, fmt.Sprintf("%s %s\n", logEndedMessage, state))
+}
+
+func (l *LogFile) write(stream string, line string) error {
+	written, err := l.file.WriteString(formatLogLine(time.Now(), stream, line))
+	l.size += int64(written)
+
+	if err != nil {
+		return fmt.Errorf("failed to write log file: %w", err)
+	}
+
+	return nil
+}
+
+func (l *LogFile) Close() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.file == nil {
+		return nil
+	}
+
+	err := l.file.Close()
+	l.file = nil
+
+	return err
+}
+
+func formatLogLine(date time.Time, stream string, line string) string {
+	return fmt.Sprintf("%s %s %s", date.Format(time.RFC3339Nano), stream, line)
+}
+
+type LogLine struct {
+	Date   time.Time
+	Stream string
+	Text   string
+}
+
+func ParseLogLine(line string) (LogLine, error) {
+	parts := strings.SplitN(line, " ", 3)
+
+	if len(parts) != 3 {
+		return LogLine{}, fmt.Errorf("malformed log line: %q", line)
+	}
+
+	date, err := time.Parse(time.RFC3339Nano, parts[0])
+
+	if err != nil {
+		return LogLine{}, fmt.Errorf("malformed log line date: %w", err)
+	}
+
+	return LogLine{
+		Date:   date,
+		Stream: parts[1],
+		Text:   parts[2],
+	}, nil
+}
+
+// Ended reports whether the line marks the end of the run.
+func (l LogLine) Ended() bool {
+	return l.Stream == StreamMeta && strings.HasPrefix(l.Text, logEndedMessage+" ")
+}
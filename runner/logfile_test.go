@@ -0,0 +1,153 @@
+package runner
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type LogFileTestSuite struct {
+	suite.Suite
+	dir string
+}
+
+func (suite *LogFileTestSuite) SetupTest() {
+	suite.dir = suite.T().TempDir()
+}
+
+func (suite *LogFileTestSuite) read(run int) string {
+	content, err := ioutil.ReadFile(LogFilePath(suite.dir, "shell.my_command", run))
+	suite.Nil(err)
+	return string(content)
+}
+
+func (suite *LogFileTestSuite) TestWrite() {
+	logFile := NewLogFile(suite.dir, "shell.my_command", 0, 1)
+
+	suite.Nil(logFile.Open())
+	suite.Nil(logFile.Write(StreamStdout, "Hello world\n"))
+	suite.Nil(logFile.Write(StreamStderr, "An error\n"))
+	suite.Nil(logFile.End(StateSuccess))
+	suite.Nil(logFile.Close())
+
+	lines := []LogLine{}
+
+	for _, line := range strings.SplitAfter(suite.read(0), "\n") {
+		if line == "" {
+			continue
+		}
+
+		parsed, err := ParseLogLine(line)
+		suite.Nil(err)
+		lines = append(lines, parsed)
+	}
+
+	suite.Len(lines, 4)
+	suite.Equal(StreamMeta, lines[0].Stream)
+	suite.Equal("started\n", lines[0].Text)
+	suite.Equal(StreamStdout, lines[1].Stream)
+	suite.Equal("Hello world\n", lines[1].Text)
+	suite.Equal(StreamStderr, lines[2].Stream)
+	suite.Equal("An error\n", lines[2].Text)
+	suite.False(lines[2].Ended())
+	suite.Equal("ended success\n", lines[3].Text)
+	suite.True(lines[3].Ended())
+}
+
+func (suite *LogFileTestSuite) TestParseMalformedLine() {
+	_, err := ParseLogLine("Hello world\n")
+	suite.NotNil(err)
+}
+
+func (suite *LogFileTestSuite) TestRotation() {
+	logFile := NewLogFile(suite.dir, "shell.my_command", 0, 3)
+
+	for _, run := range []string{"1\n", "2\n", "3\n", "4\n"} {
+		suite.Nil(logFile.Open())
+		suite.Nil(logFile.Write(StreamStdout, run))
+		suite.Nil(logFile.Close())
+	}
+
+	suite.Contains(suite.read(0), " stdout 4\n")
+	suite.Contains(suite.read(1), " stdout 3\n")
+	suite.Contains(suite.read(2), " stdout 2\n")
+
+	_, err := os.Stat(LogFilePath(suite.dir, "shell.my_command", 3))
+	suite.True(os.IsNotExist(err))
+}
+
+func (suite *LogFileTestSuite) TestPrivateLog() {
+	logFile := NewLogFile(suite.dir, "shell.my_command", 0, 1)
+
+	suite.Nil(logFile.Open())
+	suite.Nil(logFile.Close())
+
+	info, err := os.Stat(logFile.Path())
+	suite.Nil(err)
+	suite.Equal(os.FileMode(0600), info.Mode().Perm())
+}
+
+func (suite *LogFileTestSuite) TestDoesNotFollowLink() {
+	target := filepath.Join(suite.T().TempDir(), "target")
+	suite.Nil(ioutil.WriteFile(target, []byte("content\n"), 0644))
+
+	logFile := NewLogFile(suite.dir, "shell.my_command", 0, 1)
+	suite.Nil(os.Symlink(target, logFile.Path()))
+
+	suite.NotNil(logFile.Open())
+
+	content, err := ioutil.ReadFile(target)
+	suite.Nil(err)
+	suite.Equal("content\n", string(content))
+}
+
+func (suite *LogFileTestSuite) TestMaxSize() {
+	logFile := NewLogFile(suite.dir, "shell.my_command", 1024, 1)
+
+	suite.Nil(logFile.Open())
+
+	for i := 0; i < 100; i++ {
+		suite.Nil(logFile.Write(StreamStdout, fmt.Sprintf("Line %d\n", i)))
+	}
+
+	suite.Nil(logFile.End(StateSuccess))
+	suite.Nil(logFile.Close())
+
+	content := suite.read(0)
+	suite.LessOrEqual(len(content), 1024+64)
+	suite.Contains(content, " meta started\n")
+	suite.Contains(content, logTruncatedMessage+"\n")
+	suite.NotContains(content, " stdout Line 0\n")
+	suite.Contains(content, " stdout Line 99\n")
+	suite.True(strings.HasSuffix(content, " meta ended success\n"))
+
+	for _, line := range strings.SplitAfter(content, "\n") {
+		if line != "" {
+			_, err := ParseLogLine(line)
+			suite.Nil(err)
+		}
+	}
+}
+
+func (suite *LogFileTestSuite) TestLineExceedingMaxSize() {
+	logFile := NewLogFile(suite.dir, "shell.my_command", 1024, 1)
+
+	suite.Nil(logFile.Open())
+	suite.Nil(logFile.Write(StreamStdout, strings.Repeat("a", 2048)+"\n"))
+	suite.Nil(logFile.Write(StreamStdout, "Hello world\n"))
+	suite.Nil(logFile.Close())
+
+	content := suite.read(0)
+	suite.NotContains(content, "aaa")
+	suite.Contains(content, logDroppedMessage+"\n")
+	suite.Contains(content, " stdout Hello world\n")
+}
+
+func TestLogFileTestSuite(t *testing.T) {
+	suite.Run(t, new(LogFileTestSuite))
+}
@@ -2,14 +2,50 @@ package runner
 
 import "time"
 
+const (
+	StateRunning   = "running"
+	StateSuccess   = "success"
+	StateFailure   = "failure"
+	StateTimeout   = "timeout"
+	StateCancelled = "cancelled"
+	// StateStale is reported by hass-run cleanup for runs that ended without
+	// publishing their final state
+	StateStale = "stale"
+)
+
+const (
+	CancelledByUser     = "user"
+	CancelledByTimeout  = "timeout"
+	CancelledByShutdown = "shutdown"
+)
+
+type OutputLine struct {
+	Stream string    `json:"stream"`
+	Date   time.Time `json:"date"`
+	Text   string    `json:"text"`
+}
+
 type Attributes struct {
-	Output    string    `json:"output"`
-	Running   bool      `json:"running"`
-	ExitCode  int       `json:"exit_code"`
-	StartedAt time.Time `json:"started_at"`
-	UpdatedAt time.Time `json:"updated_at"`
-	EndedAt   time.Time `json:"ended_at"`
-	Duration  int       `json:"duration"`
+	Output        string                 `json:"output"`
+	DroppedBytes  int                    `json:"dropped_bytes"`
+	Stdout        string                 `json:"stdout,omitempty"`
+	Stderr        string                 `json:"stderr,omitempty"`
+	LastErrorLine string                 `json:"last_error_line"`
+	Lines         []OutputLine           `json:"lines,omitempty"`
+	Progress      *float64               `json:"progress,omitempty"`
+	ETA           string                 `json:"eta,omitempty"`
+	Extra         map[string]interface{} `json:"extra,omitempty"`
+	Running       bool                   `json:"running"`
+	ExitCode      int                    `json:"exit_code"`
+	Signal        string                 `json:"signal"`
+	CancelledBy   string                 `json:"cancelled_by"`
+	Rejected      int                    `json:"rejected"`
+	CoreDumped    bool                   `json:"core_dumped"`
+	LogPath       string                 `json:"log_path"`
+	StartedAt     time.Time              `json:"started_at"`
+	UpdatedAt     time.Time              `json:"updated_at"`
+	EndedAt       time.Time              `json:"ended_at"`
+	Duration      int                    `json:"duration"`
 }
 
 type Payload struct {
@@ -0,0 +1,60 @@
+package runner
+
+import (
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+type CommandRun interface {
+	StderrPipe() (io.ReadCloser, error)
+	StdoutPipe() (io.ReadCloser, error)
+	Start() error
+	Wait() error
+	Signal(signal os.Signal) error
+	Kill() error
+}
+
+// commandRun starts the command in its own process group so that signals
+// reach every process it spawned, not only the direct child.
+type commandRun struct {
+	*exec.Cmd
+}
+
+func newCommandRun(command Command) CommandRun {
+	execCmd := exec.Command(command.Bin(), command.Args()...)
+	execCmd.Dir = command.Dir()
+	execCmd.Env = command.Env()
+	execCmd.SysProcAttr = &syscall.SysProcAttr{
+		Setpgid:    true,
+		Credential: command.Credential(),
+	}
+
+	return &commandRun{execCmd}
+}
+
+func (c *commandRun) Signal(signal os.Signal) error {
+	if c.Process == nil {
+		return errors.New("failed to signal a non running process")
+	}
+
+	sig, ok := signal.(syscall.Signal)
+
+	if !ok {
+		return c.Process.Signal(signal)
+	}
+
+	return syscall.Kill(-c.Process.Pid, sig)
+}
+
+func (c *commandRun) Kill() error {
+	if c.Process == nil {
+		return errors.New("failed to kill a non running process")
+	}
+
+	return syscall.Kill(-c.Process.Pid, syscall.SIGKILL)
+}
+
+var Executor = newCommandRun
@@ -0,0 +1,212 @@
+package runner
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"bou.ke/monkey"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/suite"
+)
+
+type ProcessTestSuite struct {
+	suite.Suite
+}
+
+func (suite *ProcessTestSuite) SetupTest() {
+	monkey.UnpatchAll()
+	Executor = newCommandRun
+}
+
+func (suite *ProcessTestSuite) TestSignalReachesDescendants() {
+	command, err := NewCommand([]string{"sh", "-c", "sleep 30 & echo $!; wait"})
+	suite.Nil(err)
+
+	cmd := newCommandRun(command)
+
+	stdout, err := cmd.StdoutPipe()
+	suite.Nil(err)
+	suite.Nil(cmd.Start())
+
+	line, err := bufio.NewReader(stdout).ReadString('\n')
+	suite.Nil(err)
+
+	grandchild, err := strconv.Atoi(strings.TrimSpace(line))
+	suite.Nil(err)
+	suite.True(alive(grandchild))
+
+	suite.Nil(cmd.Signal(syscall.SIGTERM))
+	suite.NotNil(cmd.Wait())
+
+	suite.Eventually(func() bool { return !alive(grandchild) }, time.Second, 10*time.Millisecond)
+}
+
+func (suite *ProcessTestSuite) TestDirAndEnv() {
+	dir := suite.T().TempDir()
+
+	command, err := NewCommand(
+		[]string{"sh", "-c", "pwd; echo \"$FOO $BAR $HOME\""},
+		WithDir(dir),
+		WithClearEnv(),
+		WithEnv("FOO=foo", "BAR=bar", "FOO=baz"),
+	)
+	suite.Nil(err)
+
+	cmd := newCommandRun(command)
+
+	stdout, err := cmd.StdoutPipe()
+	suite.Nil(err)
+	suite.Nil(cmd.Start())
+
+	output, err := ioutil.ReadAll(stdout)
+	suite.Nil(err)
+	suite.Nil(cmd.Wait())
+
+	suite.Equal(dir+"\nbaz bar \n", string(output))
+}
+
+func (suite *ProcessTestSuite) TestRunnerTerminatesDescendants() {
+	pidFile := filepath.Join(suite.T().TempDir(), "grandchild.pid")
+
+	command, err := NewCommand([]string{
+		"sh",
+		"-c",
+		fmt.Sprintf("sh -c 'sleep 30' & echo $! > %s; wait", pidFile),
+	})
+	suite.Nil(err)
+
+	hassMock := &HassMock{}
+	hassMock.On("UpdateState", mock.Anything).Return(nil)
+
+	runner := NewRunner(
+		command,
+		hassMock,
+		WithTimeout(200*time.Millisecond),
+	)
+
+	runner.Run()
+
+	content, err := ioutil.ReadFile(pidFile)
+	suite.Nil(err)
+
+	grandchild, err := strconv.Atoi(strings.TrimSpace(string(content)))
+	suite.Nil(err)
+
+	suite.Eventually(func() bool { return !alive(grandchild) }, time.Second, 10*time.Millisecond)
+	suite.Equal(CancelledByTimeout, runner.snapshot().Attributes.CancelledBy)
+}
+
+func (suite *ProcessTestSuite) TestForegroundRun() {
+	command, err := NewCommand([]string{
+		"sh",
+		"-c",
+		`trap 'echo hup >&2; exit 7' HUP; printf 'a\rb\n'; while :; do sleep 0.05; done`,
+	})
+	suite.Nil(err)
+
+	var once sync.Once
+
+	hassMock := &HassMock{}
+	hassMock.On("UpdateState", mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		if strings.Contains(args.String(0), `"output":"a\rb\n"`) {
+			once.Do(func() { syscall.Kill(os.Getpid(), syscall.SIGHUP) })
+		}
+	})
+
+	var stdout, stderr bytes.Buffer
+
+	runner := NewRunner(
+		command,
+		hassMock,
+		WithPassthrough(&stdout, &stderr),
+		WithSignalForwarding(),
+	)
+
+	runner.Run()
+
+	suite.Equal("a\rb\n", stdout.String())
+	suite.Contains(stderr.String(), "hup\n")
+	suite.Equal(7, runner.ExitStatus())
+	suite.Equal(CancelledByShutdown, runner.snapshot().Attributes.CancelledBy)
+}
+
+func (suite *ProcessTestSuite) TestExitStatus() {
+	for script, status := range map[string]int{
+		"exit 0":        0,
+		"exit 3":        3,
+		"kill -TERM $$": 128 + int(syscall.SIGTERM),
+	} {
+		command, err := NewCommand([]string{"sh", "-c", script})
+		suite.Nil(err)
+
+		hassMock := &HassMock{}
+		hassMock.On("UpdateState", mock.Anything).Return(nil)
+
+		runner := NewRunner(command, hassMock)
+		runner.Run()
+
+		suite.Equal(status, runner.ExitStatus(), script)
+	}
+}
+
+func (suite *ProcessTestSuite) TestConcurrentStreams() {
+	command, err := NewCommand([]string{
+		"sh",
+		"-c",
+		"seq -f 'out %g' 1 500 & seq -f 'err %g' 1 500 >&2 & wait",
+	})
+	suite.Nil(err)
+
+	var last string
+
+	hassMock := &HassMock{}
+	hassMock.On("UpdateState", mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		last = args.String(0)
+	})
+
+	runner := NewRunner(
+		command,
+		hassMock,
+		WithSeparateStreams(),
+		WithPublishInterval(time.Millisecond),
+	)
+
+	runner.Run()
+
+	var payload Payload
+	suite.Nil(json.Unmarshal([]byte(last), &payload))
+
+	suite.Equal(StateSuccess, payload.State)
+	suite.Equal(1000, strings.Count(payload.Attributes.Output, "\n"))
+	suite.Equal(500, strings.Count(payload.Attributes.Stdout, "out "))
+	suite.Equal(500, strings.Count(payload.Attributes.Stderr, "err "))
+	suite.Equal("err 500", payload.Attributes.LastErrorLine)
+}
+
+// alive reports whether pid is running, zombies being considered dead.
+func alive(pid int) bool {
+	stat, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+
+	if err != nil {
+		return false
+	}
+
+	fields := strings.Fields(string(stat[strings.LastIndex(string(stat), ")")+1:]))
+
+	return len(fields) > 0 && fields[0] != "Z"
+}
+
+func TestProcessTestSuite(t *testing.T) {
+	suite.Run(t, new(ProcessTestSuite))
+}
@@ -0,0 +1,116 @@
+package runner
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+)
+
+var progressPresets = map[string]string{
+	// "45%" anywhere in the line
+	"percent": `(?P<progress>\d+(?:\.\d+)?)%`,
+	// "1,234,567  45%  12.34MB/s    0:01:23"
+	"rsync": `(?P<progress>\d+)%\s+\S+/s\s+(?P<eta>\d+:\d{2}:\d{2})`,
+}
+
+// ProgressParser extracts a progress percentage, and optionally an ETA, from
+// output lines. The progress is captured by the "progress" named group, or
+// the first group, and the ETA by the "eta" named group.
+type ProgressParser struct {
+	pattern       *regexp.Regexp
+	progressGroup int
+	etaGroup      int
+}
+
+// NewProgressParser accepts either the name of a preset or a regular
+// expression.
+func NewProgressParser(pattern string) (*ProgressParser, error) {
+	if preset, ok := progressPresets[pattern]; ok {
+		pattern = preset
+	}
+
+	compiled, err := regexp.Compile(pattern)
+
+	if err != nil {
+		return nil, fmt.Errorf("invalid progress pattern: %w", err)
+	}
+
+	if compiled.NumSubexp() == 0 {
+		return nil, errors.New("invalid progress pattern: a capture group is required")
+	}
+
+	parser := &ProgressParser{
+		pattern:       compiled,
+		progressGroup: compiled.SubexpIndex("progress"),
+		etaGroup:      compiled.SubexpIndex("eta"),
+	}
+
+	if parser.progressGroup < 0 {
+		parser.progressGroup = 1
+	}
+
+	return parser, nil
+}
+
+// Parse returns the progress, between 0 and 100, and the ETA found in line.
+func (p *ProgressParser) Parse(line string) (float64, string, bool) {
+	matches := p.pattern.FindStringSubmatch(line)
+
+	if matches == nil {
+		return 0, "", false
+	}
+
+	progress, err := strconv.ParseFloat(matches[p.progressGroup], 64)
+
+	if err != nil {
+		return 0, "", false
+	}
+
+	if progress < 0 {
+		progress = 0
+	} else if progress > 100 {
+		progress = 100
+	}
+
+	eta := ""
+
+	if p.etaGroup >= 0 {
+		eta = matches[p.etaGroup]
+	}
+
+	return progress, eta, true
+}
+
+// scanProgressLines splits lines on new lines and carriage returns, which
+// are used to redraw progress bars. Lines ending with a carriage return are
+// prefixed by it so that they can be told apart.
+func scanProgressLines(data []byte, atEOF bool) (int, []byte, error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+
+	if i := bytes.IndexAny(data, "\r\n"); i >= 0 {
+		if data[i] == '\n' {
+			return i + 1, data[:i], nil
+		}
+
+		if i+1 < len(data) && data[i+1] == '\n' {
+			return i + 2, data[:i], nil
+		}
+
+		if i+1 == len(data) && !atEOF {
+			// wait to know whether it is followed by a new line
+			return 0, nil, nil
+		}
+
+		return i + 1, append([]byte{'\r'}, data[:i]...), nil
+	}
+
+	if atEOF {
+		return len(data), data, nil
+	}
+
+	return 0, nil, nil
+}
@@ -0,0 +1,78 @@
+package runner
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type ProgressTestSuite struct {
+	suite.Suite
+}
+
+func (suite *ProgressTestSuite) TestPercentPreset() {
+	parser, err := NewProgressParser("percent")
+	suite.Nil(err)
+
+	progress, eta, ok := parser.Parse("frame=120 42.5% done")
+	suite.True(ok)
+	suite.Equal(42.5, progress)
+	suite.Equal("", eta)
+
+	_, _, ok = parser.Parse("no progress here")
+	suite.False(ok)
+}
+
+func (suite *ProgressTestSuite) TestRsyncPreset() {
+	parser, err := NewProgressParser("rsync")
+	suite.Nil(err)
+
+	progress, eta, ok := parser.Parse("    158,334,976  45%   75.48MB/s    0:00:02")
+	suite.True(ok)
+	suite.Equal(45.0, progress)
+	suite.Equal("0:00:02", eta)
+}
+
+func (suite *ProgressTestSuite) TestCustomPattern() {
+	parser, err := NewProgressParser(`ETA (?P<eta>\S+) \[(?P<progress>[\d.]+)`)
+	suite.Nil(err)
+
+	progress, eta, ok := parser.Parse("ETA 3:12 [12.50%]")
+	suite.True(ok)
+	suite.Equal(12.5, progress)
+	suite.Equal("3:12", eta)
+
+	parser, err = NewProgressParser(`step (\d+)/100`)
+	suite.Nil(err)
+
+	progress, _, ok = parser.Parse("step 250/100")
+	suite.True(ok)
+	suite.Equal(100.0, progress)
+}
+
+func (suite *ProgressTestSuite) TestInvalidPattern() {
+	_, err := NewProgressParser(`\d+%`)
+	suite.NotNil(err)
+
+	_, err = NewProgressParser(`(\d+`)
+	suite.NotNil(err)
+}
+
+func (suite *ProgressTestSuite) TestScanProgressLines() {
+	scanner := bufio.NewScanner(strings.NewReader("a\r\nb\rc\rd\ne"))
+	scanner.Split(scanProgressLines)
+
+	var tokens []string
+
+	for scanner.Scan() {
+		tokens = append(tokens, scanner.Text())
+	}
+
+	suite.Equal([]string{"a", "\rb", "\rc", "d", "e"}, tokens)
+}
+
+func TestProgressTestSuite(t *testing.T) {
+	suite.Run(t, new(ProgressTestSuite))
+}
@@ -0,0 +1,122 @@
+package runner
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// Publisher sits between the Runner and Home-Assistant: it sends at most one
+// update per interval, only keeping the latest pending state, so that chatty
+// commands neither flood Home-Assistant nor wait on it.
+type Publisher struct {
+	hass       Hass
+	interval   time.Duration
+	mutex      sync.Mutex
+	pending    string
+	hasPending bool
+	err        error
+	wake       chan struct{}
+	stop       chan struct{}
+	stopped    chan struct{}
+}
+
+func NewPublisher(hass Hass, interval time.Duration) *Publisher {
+	publisher := &Publisher{
+		hass:     hass,
+		interval: interval,
+		wake:     make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+
+	if interval > 0 {
+		go publisher.loop()
+	} else {
+		close(publisher.stopped)
+	}
+
+	return publisher
+}
+
+func (p *Publisher) Publish(json string) {
+	if p.interval <= 0 {
+		p.send(json)
+		return
+	}
+
+	p.mutex.Lock()
+	p.pending = json
+	p.hasPending = true
+	p.mutex.Unlock()
+
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Close stops the publisher, synchronously sends the pending update if any
+// and returns the error of the last update sent.
+func (p *Publisher) Close() error {
+	if p.interval > 0 {
+		close(p.stop)
+		<-p.stopped
+		p.flush()
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return p.err
+}
+
+func (p *Publisher) loop() {
+	defer close(p.stopped)
+
+	for {
+		select {
+		case <-p.wake:
+		case <-p.stop:
+			return
+		}
+
+		p.flush()
+
+		select {
+		case <-time.After(p.interval):
+		case <-p.stop:
+			return
+		}
+	}
+}
+
+func (p *Publisher) flush() {
+	p.mutex.Lock()
+
+	if !p.hasPending {
+		p.mutex.Unlock()
+		return
+	}
+
+	json := p.pending
+	p.hasPending = false
+	p.mutex.Unlock()
+
+	p.send(json)
+}
+
+func (p *Publisher) send(json string) {
+	err := p.hass.UpdateState(json)
+
+	if err != nil {
+		log.Printf(
+			"Failed to publish update: %s",
+			err.Error(),
+		)
+	}
+
+	p.mutex.Lock()
+	p.err = err
+	p.mutex.Unlock()
+}
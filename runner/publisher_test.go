@@ -0,0 +1,70 @@
+package runner
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/suite"
+)
+
+type PublisherTestSuite struct {
+	suite.Suite
+	hassMock *HassMock
+}
+
+func (suite *PublisherTestSuite) SetupTest() {
+	suite.hassMock = &HassMock{}
+}
+
+func (suite *PublisherTestSuite) TestWithoutInterval() {
+	suite.hassMock.On("UpdateState", "1").Return(nil).Once()
+	suite.hassMock.On("UpdateState", "2").Return(errors.New("FAILED")).Once()
+
+	publisher := NewPublisher(suite.hassMock, 0)
+	publisher.Publish("1")
+	publisher.Publish("2")
+
+	suite.NotNil(publisher.Close())
+	suite.hassMock.AssertExpectations(suite.T())
+}
+
+func (suite *PublisherTestSuite) TestCoalescing() {
+	sent := make(chan string, 10)
+	release := make(chan struct{})
+
+	suite.hassMock.On("UpdateState", mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		sent <- args.String(0)
+		<-release
+	})
+
+	publisher := NewPublisher(suite.hassMock, time.Hour)
+	publisher.Publish("1")
+
+	suite.Equal("1", <-sent)
+
+	publisher.Publish("2")
+	publisher.Publish("3")
+	publisher.Publish("4")
+	close(release)
+
+	suite.Nil(publisher.Close())
+
+	suite.Equal("4", <-sent)
+	suite.Len(sent, 0)
+}
+
+func (suite *PublisherTestSuite) TestCloseReturnsLastError() {
+	suite.hassMock.On("UpdateState", "1").Return(errors.New("FAILED")).Once()
+
+	publisher := NewPublisher(suite.hassMock, time.Hour)
+	publisher.Publish("1")
+
+	suite.NotNil(publisher.Close())
+	suite.hassMock.AssertExpectations(suite.T())
+}
+
+func TestPublisherTestSuite(t *testing.T) {
+	suite.Run(t, new(PublisherTestSuite))
+}
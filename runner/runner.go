@@ -4,12 +4,12 @@ import (
 	"bufio"
 	"context"
 	"encoding/json"
-	"errors"
 	"io"
 	"log"
 	"os"
 	"os/exec"
 	"os/signal"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
@@ -17,87 +17,262 @@ import (
 
 const CommandFailedExitCode = -10
 
+// CancelSignal is sent to the runner to cancel the command on behalf of the
+// user, other termination signals meaning that hass-run is shutting down.
+const CancelSignal = syscall.SIGUSR1
+
+// RejectSignal is sent to the runner when another run of the same job was
+// rejected because this one is still running.
+const RejectSignal = syscall.SIGUSR2
+
 type Hass interface {
 	UpdateState(json string) error
 }
 
+type Spool interface {
+	Store(json string) error
+	Load() (string, error)
+	Clear() error
+}
+
 type Runner struct {
-	command   Command
-	hass      Hass
-	output    string
-	running   bool
-	exitCode  int
-	startedAt time.Time
-	updatedAt time.Time
-	endedAt   time.Time
-	duration  time.Duration
+	command         Command
+	hass            Hass
+	publisher       *Publisher
+	sinks           []Hass
+	sinkPublishers  []*Publisher
+	logFile         *LogFile
+	publishInterval time.Duration
+	spool           Spool
+	linger          time.Duration
+	undelivered     string
+	timeout         time.Duration
+	termination     TerminationPolicy
+	outputPolicy    OutputPolicy
+	separateStreams bool
+	structured      bool
+	progress        *ProgressParser
+	onStart         func(err error)
+	passthrough     map[string]io.Writer
+	forwardSignals  bool
+	eventBus        EventBus
+	events          map[string]bool
+	eventLines      int
+	eventQueue      *eventQueue
+	serviceCaller   ServiceCaller
+	hooks           []*Hook
+	mutex           sync.Mutex
+	state           runState
+	notifyMutex     sync.Mutex
 }
 
-type CommandRun interface {
-	StderrPipe() (io.ReadCloser, error)
-	StdoutPipe() (io.ReadCloser, error)
-	Start() error
-	Wait() error
-	Kill() error
+type Option func(r *Runner)
+
+func WithOutputPolicy(policy OutputPolicy) Option {
+	return func(r *Runner) {
+		r.outputPolicy = policy
+	}
 }
 
-type commandRun struct {
-	*exec.Cmd
+// WithSeparateStreams also publishes stdout and stderr in their own
+// attributes, each of them following the output policy.
+func WithSeparateStreams() Option {
+	return func(r *Runner) {
+		r.separateStreams = true
+	}
 }
 
-func (c *commandRun) Kill() error {
-	if c.Process == nil {
-		return errors.New("failed to kill a non running process")
+// WithStructuredOutput publishes the retained lines tagged with their stream
+// and date.
+func WithStructuredOutput() Option {
+	return func(r *Runner) {
+		r.structured = true
 	}
+}
 
-	return c.Process.Kill()
+// WithProgress extracts the progress of the command from its output lines.
+func WithProgress(parser *ProgressParser) Option {
+	return func(r *Runner) {
+		r.progress = parser
+	}
 }
 
-var Executor = func(cmd string, args []string) CommandRun {
-	execCmd := exec.Command(cmd, args...)
-	return &commandRun{execCmd}
+func WithPublishInterval(interval time.Duration) Option {
+	return func(r *Runner) {
+		r.publishInterval = interval
+	}
+}
+
+// WithSpool stores the final state in spool until it is delivered, Linger
+// retrying it for at most linger before giving up.
+func WithSpool(spool Spool, linger time.Duration) Option {
+	return func(r *Runner) {
+		r.spool = spool
+		r.linger = linger
+	}
 }
 
-func NewRunner(command Command, hass Hass) *Runner {
-	return &Runner{
+// WithTimeout terminates the command once it has been running for timeout.
+func WithTimeout(timeout time.Duration) Option {
+	return func(r *Runner) {
+		r.timeout = timeout
+	}
+}
+
+func WithTermination(policy TerminationPolicy) Option {
+	return func(r *Runner) {
+		r.termination = policy
+	}
+}
+
+// WithSink also publishes every state to sink, next to Home-Assistant.
+func WithSink(sink Hass) Option {
+	return func(r *Runner) {
+		r.sinks = append(r.sinks, sink)
+	}
+}
+
+// WithLogFile writes the whole output of every run to logFile.
+func WithLogFile(logFile *LogFile) Option {
+	return func(r *Runner) {
+		r.logFile = logFile
+	}
+}
+
+// WithOnStart calls onStart once the command started, or with the error that
+// prevented it from starting.
+func WithOnStart(onStart func(err error)) Option {
+	return func(r *Runner) {
+		r.onStart = onStart
+	}
+}
+
+// WithPassthrough copies the output of the command verbatim to stdout and
+// stderr instead of logging it.
+func WithPassthrough(stdout io.Writer, stderr io.Writer) Option {
+	return func(r *Runner) {
+		r.passthrough = map[string]io.Writer{
+			StreamStdout: stdout,
+			StreamStderr: stderr,
+		}
+	}
+}
+
+// WithSignalForwarding terminates the command with the signal received by
+// hass-run, instead of the one of the termination policy.
+func WithSignalForwarding() Option {
+	return func(r *Runner) {
+		r.forwardSignals = true
+	}
+}
+
+// WithEvents fires events on bus when the command starts, finishes, fails
+// or outputs a line, end events including its last outputLines lines.
+func WithEvents(bus EventBus, events []string, outputLines int) Option {
+	return func(r *Runner) {
+		r.eventBus = bus
+		r.events = map[string]bool{}
+		r.eventLines = outputLines
+
+		for _, event := range events {
+			r.events[event] = true
+		}
+	}
+}
+
+// WithHooks calls the services of hooks through caller once the command
+// succeeded or failed.
+func WithHooks(caller ServiceCaller, hooks ...*Hook) Option {
+	return func(r *Runner) {
+		r.serviceCaller = caller
+		r.hooks = append(r.hooks, hooks...)
+	}
+}
+
+func NewRunner(command Command, hass Hass, options ...Option) *Runner {
+	runner := &Runner{
 		command: command,
 		hass:    hass,
+		state:   newRunState(OutputPolicy{}),
+		termination: TerminationPolicy{
+			Signal:      syscall.SIGTERM,
+			GracePeriod: DefaultGracePeriod,
+		},
+	}
+
+	for _, option := range options {
+		option(runner)
 	}
+
+	return runner
 }
 
 func (r *Runner) Run() {
-	r.output = ""
-	r.running = true
-	r.startedAt = time.Now()
-	r.endedAt = time.Time{}
-	r.updatedAt = time.Time{}
-	r.duration = 0
+	r.update(func(state *runState) {
+		*state = newRunState(r.outputPolicy)
+		state.running = true
+		state.startedAt = time.Now()
+	})
 
 	context, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
 	cancelChan := make(chan os.Signal, 1)
-	signal.Notify(cancelChan, syscall.SIGTERM)
-
-	r.Notify()
-	defer r.Notify()
+	signal.Notify(
+		cancelChan,
+		CancelSignal,
+		syscall.SIGTERM,
+		syscall.SIGINT,
+		syscall.SIGHUP,
+	)
+	defer signal.Stop(cancelChan)
+
+	rejectChan := make(chan os.Signal, 1)
+	signal.Notify(rejectChan, RejectSignal)
+	defer signal.Stop(rejectChan)
+
+	var deadline <-chan time.Time
+
+	if r.timeout > 0 {
+		timer := time.NewTimer(r.timeout)
+		defer timer.Stop()
+		deadline = timer.C
+	}
 
-	cmd := Executor(r.command.Bin(), r.command.Args())
+	if r.logFile != nil {
+		err := r.logFile.Open()
 
-	go func() {
-		select {
-		case <-cancelChan:
-			cmd.Kill()
-		case <-context.Done():
+		if err != nil {
+			log.Printf("Failed to open log file: %s", err.Error())
 		}
-	}()
+
+		defer r.logFile.Close()
+	}
+
+	r.publisher = NewPublisher(r.hass, r.publishInterval)
+	r.sinkPublishers = nil
+
+	for _, sink := range r.sinks {
+		r.sinkPublishers = append(r.sinkPublishers, NewPublisher(sink, r.publishInterval))
+	}
+
+	r.eventQueue = nil
+
+	if r.eventBus != nil && len(r.events) > 0 {
+		r.eventQueue = newEventQueue(r.eventBus)
+	}
+
+	r.Notify()
+	defer r.finish()
+
+	cmd := Executor(r.command)
 
 	stdout, err := cmd.StdoutPipe()
 
 	if err != nil {
 		log.Printf("Failed to acquire STDOUT pipe: %s", err.Error())
 		r.appendOutput(err.Error() + "\n")
-		r.Notify()
+		r.started(err)
 		return
 	}
 
@@ -106,28 +281,72 @@ func (r *Runner) Run() {
 	if err != nil {
 		log.Printf("Failed to acquire STDERR pipe: %s", err.Error())
 		r.appendOutput(err.Error() + "\n")
+		r.started(err)
 		return
 	}
 
 	var wg sync.WaitGroup
 
-	go r.ReadStream(&wg, &r.output, stdout)
-
-	go r.ReadStream(&wg, &r.output, stderr)
+	wg.Add(2)
+	go r.ReadStream(&wg, StreamStdout, stdout)
+	go r.ReadStream(&wg, StreamStderr, stderr)
 
 	err = cmd.Start()
 
 	if err != nil {
 		log.Printf("Failed to run command: %s", err.Error())
 		r.appendOutput(err.Error() + "\n")
+		r.started(err)
 		return
 	}
 
+	r.started(nil)
+	r.fireEvent(EventStarted, map[string]interface{}{
+		"started_at": r.snapshot().Attributes.StartedAt,
+	})
+
+	// signals received before the command started are buffered in
+	// cancelChan and handled now that there is a process to terminate
+	go func() {
+		select {
+		case sig := <-cancelChan:
+			if sig == CancelSignal {
+				r.terminate(cmd, context.Done(), CancelledByUser, r.termination.Signal)
+			} else if r.forwardSignals {
+				r.terminate(cmd, context.Done(), CancelledByShutdown, sig.(syscall.Signal))
+			} else {
+				r.terminate(cmd, context.Done(), CancelledByShutdown, r.termination.Signal)
+			}
+		case <-deadline:
+			r.terminate(cmd, context.Done(), CancelledByTimeout, r.termination.Signal)
+		case <-context.Done():
+		}
+	}()
+
+	go func() {
+		for {
+			select {
+			case <-rejectChan:
+				r.update(func(state *runState) {
+					state.rejected++
+				})
+				r.Notify()
+			case <-context.Done():
+				return
+			}
+		}
+	}()
+
 	wg.Wait()
 
 	err = cmd.Wait()
-	r.running = false
-	r.endedAt = time.Now()
+	cancel()
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.state.running = false
+	r.state.endedAt = time.Now()
 
 	if exitCode, ok := err.(*exec.ExitError); ok {
 		log.Printf(
@@ -135,7 +354,12 @@ func (r *Runner) Run() {
 			exitCode.ExitCode(),
 		)
 
-		r.exitCode = exitCode.ExitCode()
+		r.state.exitCode = exitCode.ExitCode()
+
+		if status, ok := exitCode.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+			r.state.signal = SignalName(status.Signal())
+			r.state.coreDumped = status.CoreDump()
+		}
 
 		return
 	}
@@ -146,77 +370,449 @@ func (r *Runner) Run() {
 			err.Error(),
 		)
 
-		r.exitCode = CommandFailedExitCode
-		r.appendOutput(err.Error() + "\n")
+		r.state.exitCode = CommandFailedExitCode
+		r.state.appendOutput(err.Error() + "\n")
+	}
+}
+
+// ExitStatus returns the exit status of the last run, as reported by shells:
+// the exit code of the command, 128 plus the signal number if a signal
+// killed it or 1 if it could not run.
+func (r *Runner) ExitStatus() int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.state.signal != "" {
+		if sig, err := ParseSignal(r.state.signal); err == nil {
+			return 128 + int(sig)
+		}
+	}
+
+	if r.state.exitCode < 0 {
+		return 1
+	}
+
+	return r.state.exitCode
+}
+
+func (r *Runner) started(err error) {
+	if r.onStart != nil {
+		r.onStart(err)
+	}
+}
+
+func (r *Runner) update(fn func(state *runState)) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	fn(&r.state)
+}
+
+// terminate stops the command with sig, killing it if it is still running
+// after the grace period, done being closed once the command exited.
+func (r *Runner) terminate(cmd CommandRun, done <-chan struct{}, cancelledBy string, sig syscall.Signal) {
+	r.update(func(state *runState) {
+		state.cancelledBy = cancelledBy
+	})
+
+	log.Printf(
+		"Terminating command (cancelled by %s) with %s",
+		cancelledBy,
+		SignalName(sig),
+	)
+
+	err := cmd.Signal(sig)
+
+	if err != nil {
+		log.Printf(
+			"Failed to signal command: %s",
+			err.Error(),
+		)
+	}
+
+	select {
+	case <-done:
+		return
+	case <-time.After(r.termination.GracePeriod):
+	}
+
+	log.Printf(
+		"Command still running after %s, killing it",
+		r.termination.GracePeriod,
+	)
+
+	err = cmd.Kill()
+
+	if err != nil {
+		log.Printf(
+			"Failed to kill command: %s",
+			err.Error(),
+		)
 	}
 }
 
+// ReadStream consumes reader until it is closed, wg being marked as done
+// afterwards.
 func (r *Runner) ReadStream(
 	wg *sync.WaitGroup,
-	output *string,
+	stream string,
 	reader io.ReadCloser,
 ) {
-	wg.Add(1)
-	go func() {
-		scanner := bufio.NewScanner(reader)
+	defer wg.Done()
+
+	var source io.Reader = reader
+
+	if writer, ok := r.passthrough[stream]; ok {
+		source = io.TeeReader(reader, writer)
+	}
+
+	scanner := bufio.NewScanner(source)
+
+	if r.progress != nil {
+		scanner.Split(scanProgressLines)
+	}
 
-		for scanner.Scan() {
-			log.Println(scanner.Text())
-			r.appendOutput(scanner.Text() + "\n")
-			r.Notify()
+	for scanner.Scan() {
+		text := scanner.Text()
+
+		if r.progress != nil {
+			redrawn := strings.HasPrefix(text, "\r")
+			text = strings.TrimPrefix(text, "\r")
+
+			if progress, eta, ok := r.progress.Parse(text); ok {
+				r.update(func(state *runState) {
+					state.setProgress(progress, eta)
+				})
+			}
+
+			// a line redrawn by the next one is only used for its progress
+			if redrawn {
+				r.Notify()
+				continue
+			}
 		}
-		wg.Done()
-	}()
+
+		if stream == StreamStdout {
+			attributes, ok, err := parseControlLine(text)
+
+			if err != nil {
+				log.Printf("Ignoring control line: %s", err.Error())
+				continue
+			}
+
+			if ok {
+				r.update(func(state *runState) {
+					state.setExtra(attributes)
+				})
+				r.Notify()
+				continue
+			}
+		}
+
+		if r.passthrough == nil {
+			log.Println(text)
+		}
+
+		r.appendLine(stream, text)
+		r.writeLog(stream, text+"\n")
+		r.Notify()
+		r.fireEvent(EventOutput, map[string]interface{}{
+			"stream": stream,
+			"line":   text,
+		})
+	}
+}
+
+func (r *Runner) writeLog(stream string, line string) {
+	if r.logFile == nil {
+		return
+	}
+
+	err := r.logFile.Write(stream, line)
+
+	if err != nil {
+		log.Printf("Failed to write log file: %s", err.Error())
+	}
+}
+
+func (r *Runner) appendLine(stream string, text string) {
+	line := OutputLine{
+		Stream: stream,
+		Date:   time.Now(),
+		Text:   text + "\n",
+	}
+
+	r.update(func(state *runState) {
+		state.appendLine(line)
+	})
 }
 
 func (r *Runner) appendOutput(content string) {
-	r.output += content
-	r.updatedAt = time.Now()
+	r.update(func(state *runState) {
+		state.appendOutput(content)
+	})
 }
 
-func (r *Runner) Notify() {
-	var state string
-	if r.running {
-		state = "running"
-	} else {
-		if r.exitCode == 0 {
-			state = "success"
-		} else {
-			state = "failure"
+func (r *Runner) finish() {
+	r.update(func(state *runState) {
+		if !state.running {
+			return
 		}
+
+		state.running = false
+		state.endedAt = time.Now()
+
+		if state.exitCode == 0 {
+			state.exitCode = CommandFailedExitCode
+		}
+	})
+
+	// the final state survives the daemon until it is delivered
+	final := r.spoolFinal()
+
+	r.Notify()
+	r.fireEndEvents()
+
+	defer r.closeEvents()
+
+	if len(r.hooks) > 0 {
+		r.callHooks(r.snapshot())
 	}
 
-	payload := Payload{
-		State: state,
-		Attributes: Attributes{
-			Output:    r.output,
-			ExitCode:  r.exitCode,
-			StartedAt: r.startedAt,
-			UpdatedAt: r.updatedAt,
-			EndedAt:   r.endedAt,
-		},
+	if r.logFile != nil {
+		err := r.logFile.End(r.snapshot().State)
+
+		if err != nil {
+			log.Printf("Failed to write log file: %s", err.Error())
+		}
+	}
+
+	for _, publisher := range r.sinkPublishers {
+		publisher.Close()
+	}
+
+	if r.publisher.Close() != nil {
+		if final == "" {
+			log.Printf("Final state was not delivered")
+			return
+		}
+
+		r.undelivered = final
+		return
+	}
+
+	if final != "" {
+		r.clearSpool()
+	}
+}
+
+// fireEvent fires the event name if it is enabled.
+func (r *Runner) fireEvent(name string, data map[string]interface{}) {
+	if r.eventQueue == nil || !r.events[name] {
+		return
+	}
+
+	// a chatty command must not wait on Home-Assistant
+	r.eventQueue.fire(name, data, name == EventOutput)
+}
+
+func (r *Runner) fireEndEvents() {
+	payload := r.snapshot()
+
+	data := func() map[string]interface{} {
+		return map[string]interface{}{
+			"state":        payload.State,
+			"exit_code":    payload.Attributes.ExitCode,
+			"signal":       payload.Attributes.Signal,
+			"cancelled_by": payload.Attributes.CancelledBy,
+			"duration":     payload.Attributes.Duration,
+			"output":       tailLines(payload.Attributes.Output, r.eventLines),
+		}
+	}
+
+	r.fireEvent(EventFinished, data())
+
+	if payload.State != StateSuccess {
+		r.fireEvent(EventFailed, data())
+	}
+}
+
+func (r *Runner) closeEvents() {
+	if r.eventQueue != nil {
+		r.eventQueue.close()
 	}
+}
 
-	if (r.endedAt != time.Time{}) {
-		payload.Attributes.Duration = int(r.endedAt.Sub(r.startedAt).Seconds())
+var lingerRetryInterval = 30 * time.Second
+
+// spoolFinal stores the final state in the spool, returning it unless it
+// could not be stored.
+func (r *Runner) spoolFinal() string {
+	if r.spool == nil {
+		return ""
 	}
 
-	bytes, err := json.Marshal(payload)
+	json, err := r.payload()
 
 	if err != nil {
 		log.Printf(
 			"Failed to marshal payload: %s",
 			err.Error(),
 		)
+		return ""
+	}
+
+	err = r.spool.Store(json)
+
+	if err != nil {
+		log.Printf(
+			"Failed to spool final state: %s",
+			err.Error(),
+		)
+		return ""
+	}
+
+	return json
+}
+
+func (r *Runner) clearSpool() {
+	err := r.spool.Clear()
+
+	if err != nil {
+		log.Printf(
+			"Failed to clear spooled state: %s",
+			err.Error(),
+		)
+	}
+}
+
+// Linger retries delivering the final state the run could not deliver until
+// it succeeds or the linger duration is over. It is meant to be called once
+// Run returned and the resources of the run were released.
+func (r *Runner) Linger() {
+	if r.undelivered == "" {
+		return
+	}
+
+	deadline := time.Now().Add(r.linger)
+
+	for time.Now().Before(deadline) {
+		time.Sleep(lingerRetryInterval)
+
+		// the next runs deliver or clear the spooled state
+		spooled, err := r.spool.Load()
+
+		if err == nil && spooled != r.undelivered {
+			log.Printf("Final state was superseded, giving up delivering it")
+			return
+		}
+
+		err = r.hass.UpdateState(r.undelivered)
+
+		if err != nil {
+			log.Printf(
+				"Failed to deliver final state: %s",
+				err.Error(),
+			)
+			continue
+		}
+
+		r.clearSpool()
+
 		return
 	}
 
-	err = r.hass.UpdateState(string(bytes))
+	log.Printf("Giving up delivering final state, it is kept in spool")
+}
+
+// Notify publishes a snapshot of the current state, snapshots being
+// published in the order they are taken.
+func (r *Runner) Notify() {
+	r.notifyMutex.Lock()
+	defer r.notifyMutex.Unlock()
+
+	json, err := r.payload()
 
 	if err != nil {
 		log.Printf(
-			"Failed to publish update: %s",
+			"Failed to marshal payload: %s",
 			err.Error(),
 		)
+		return
 	}
+
+	r.publisher.Publish(json)
+
+	for _, publisher := range r.sinkPublishers {
+		publisher.Publish(json)
+	}
+}
+
+func (r *Runner) logPath() string {
+	if r.logFile == nil {
+		return ""
+	}
+
+	return r.logFile.Path()
+}
+
+func (r *Runner) snapshot() Payload {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	payload := Payload{
+		State: r.state.name(),
+		Attributes: Attributes{
+			Output:        r.state.output.String(),
+			DroppedBytes:  r.state.output.DroppedBytes(),
+			LastErrorLine: r.state.lastErrorLine,
+			Progress:      r.state.progress,
+			ETA:           r.state.eta,
+			ExitCode:      r.state.exitCode,
+			Signal:        r.state.signal,
+			CancelledBy:   r.state.cancelledBy,
+			Rejected:      r.state.rejected,
+			CoreDumped:    r.state.coreDumped,
+			LogPath:       r.logPath(),
+			StartedAt:     r.state.startedAt,
+			UpdatedAt:     r.state.updatedAt,
+			EndedAt:       r.state.endedAt,
+		},
+	}
+
+	if len(r.state.extra) > 0 {
+		payload.Attributes.Extra = make(map[string]interface{}, len(r.state.extra))
+
+		for key, value := range r.state.extra {
+			payload.Attributes.Extra[key] = value
+		}
+	}
+
+	if r.separateStreams {
+		payload.Attributes.Stdout = r.state.stdout.String()
+		payload.Attributes.Stderr = r.state.stderr.String()
+	}
+
+	if r.structured {
+		for _, line := range r.state.output.Lines() {
+			line.Text = strings.TrimSuffix(line.Text, "\n")
+			payload.Attributes.Lines = append(payload.Attributes.Lines, line)
+		}
+	}
+
+	if (r.state.endedAt != time.Time{}) {
+		payload.Attributes.Duration = int(r.state.endedAt.Sub(r.state.startedAt).Seconds())
+	}
+
+	return payload
+}
+
+func (r *Runner) payload() (string, error) {
+	bytes, err := json.Marshal(r.snapshot())
+
+	if err != nil {
+		return "", err
+	}
+
+	return string(bytes), nil
 }
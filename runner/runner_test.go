@@ -4,6 +4,10 @@ import (
 	"encoding/json"
 	"errors"
 	"io"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
 	"testing"
 	"time"
 
@@ -20,6 +24,30 @@ func (h *HassMock) UpdateState(json string) error {
 	return h.Called(json).Error(0)
 }
 
+type EventBusMock struct {
+	mock.Mock
+}
+
+func (e *EventBusMock) Entity() string {
+	return "shell.test"
+}
+
+func (e *EventBusMock) FireEvent(eventType string, data interface{}) error {
+	return e.Called(eventType, data).Error(0)
+}
+
+type ServiceCallerMock struct {
+	mock.Mock
+}
+
+func (s *ServiceCallerMock) Entity() string {
+	return "shell.test"
+}
+
+func (s *ServiceCallerMock) CallService(domain string, service string, data interface{}) error {
+	return s.Called(domain, service, data).Error(0)
+}
+
 var CommandBin = "cmd"
 var CommandArgs = []string{"a", "b c"}
 
@@ -45,10 +73,31 @@ func (c *CmdMock) Wait() error {
 	return c.Called().Error(0)
 }
 
+func (c *CmdMock) Signal(signal os.Signal) error {
+	return c.Called(signal).Error(0)
+}
+
 func (c *CmdMock) Kill() error {
 	return c.Called().Error(0)
 }
 
+type SpoolMock struct {
+	mock.Mock
+}
+
+func (s *SpoolMock) Store(json string) error {
+	return s.Called(json).Error(0)
+}
+
+func (s *SpoolMock) Load() (string, error) {
+	args := s.Called()
+	return args.String(0), args.Error(1)
+}
+
+func (s *SpoolMock) Clear() error {
+	return s.Called().Error(0)
+}
+
 type RunnerTestSuite struct {
 	suite.Suite
 	hassMock *HassMock
@@ -60,18 +109,23 @@ func (suite *RunnerTestSuite) SetupTest() {
 	suite.hassMock = &HassMock{}
 	suite.cmdMock = &CmdMock{}
 
-	Executor = func(cmd string, args []string) CommandRun {
-		suite.Equal(CommandBin, cmd)
-		suite.Equal(CommandArgs, args)
+	Executor = func(command Command) CommandRun {
+		suite.Equal(CommandBin, command.Bin())
+		suite.Equal(CommandArgs, command.Args())
 		return suite.cmdMock
 	}
 
+	suite.runner = suite.newRunner()
+}
+
+func (suite *RunnerTestSuite) newRunner(options ...Option) *Runner {
 	command, err := NewCommand(append([]string{CommandBin}, CommandArgs...))
 	suite.Nil(err)
 
-	suite.runner = NewRunner(
+	return NewRunner(
 		command,
 		suite.hassMock,
+		options...,
 	)
 }
 
@@ -153,9 +207,10 @@ func (suite *RunnerTestSuite) TestValidCommand() {
 		suite.Payload(Payload{
 			State: "running",
 			Attributes: Attributes{
-				Output:    "Hello world\nAn error\n",
-				StartedAt: startDate,
-				UpdatedAt: output2Date,
+				Output:        "Hello world\nAn error\n",
+				LastErrorLine: "An error",
+				StartedAt:     startDate,
+				UpdatedAt:     output2Date,
 			},
 		}),
 	).Return(nil).Once().Run(func(args mock.Arguments) { notified <- 1 })
@@ -165,12 +220,13 @@ func (suite *RunnerTestSuite) TestValidCommand() {
 		suite.Payload(Payload{
 			State: "failure",
 			Attributes: Attributes{
-				Output:    "Hello world\nAn error\nFAILED\n",
-				ExitCode:  -10,
-				StartedAt: startDate,
-				UpdatedAt: endDate,
-				EndedAt:   endDate,
-				Duration:  20,
+				Output:        "Hello world\nAn error\nFAILED\n",
+				LastErrorLine: "An error",
+				ExitCode:      -10,
+				StartedAt:     startDate,
+				UpdatedAt:     endDate,
+				EndedAt:       endDate,
+				Duration:      20,
 			},
 		}),
 	).Return(nil).Once()
@@ -178,6 +234,518 @@ func (suite *RunnerTestSuite) TestValidCommand() {
 	suite.runner.Run()
 }
 
+func (suite *RunnerTestSuite) TestFinalStateIsSpooled() {
+	monkey.UnpatchAll()
+	lingerRetryInterval = 0
+
+	spoolMock := &SpoolMock{}
+	suite.runner = suite.newRunner(WithSpool(spoolMock, time.Hour))
+
+	stdoutReader, _ := io.Pipe()
+	suite.cmdMock.On("StdoutPipe").Return(stdoutReader, nil)
+
+	stderrReader, _ := io.Pipe()
+	suite.cmdMock.On("StderrPipe").Return(stderrReader, nil)
+
+	suite.cmdMock.On("Start").Return(errors.New("FAILED"))
+
+	suite.hassMock.On("UpdateState", suite.State("running")).Return(nil).Once()
+	suite.hassMock.On("UpdateState", suite.State("failure")).Return(errors.New("OFFLINE")).Once()
+	suite.hassMock.On("UpdateState", suite.State("failure")).Return(nil).Once()
+
+	spoolMock.On("Store", suite.State("failure")).Return(nil).Once()
+
+	suite.runner.Run()
+
+	spoolMock.AssertExpectations(suite.T())
+
+	spooled := spoolMock.Calls[0].Arguments.String(0)
+
+	spoolMock.On("Load").Return(spooled, nil).Once()
+	spoolMock.On("Clear").Return(nil).Once()
+
+	suite.runner.Linger()
+
+	suite.hassMock.AssertExpectations(suite.T())
+	spoolMock.AssertExpectations(suite.T())
+}
+
+func (suite *RunnerTestSuite) TestDeliveredFinalStateIsCleared() {
+	monkey.UnpatchAll()
+
+	spoolMock := &SpoolMock{}
+	suite.runner = suite.newRunner(WithSpool(spoolMock, time.Hour))
+
+	stdoutReader, _ := io.Pipe()
+	suite.cmdMock.On("StdoutPipe").Return(stdoutReader, nil)
+
+	stderrReader, _ := io.Pipe()
+	suite.cmdMock.On("StderrPipe").Return(stderrReader, nil)
+
+	suite.cmdMock.On("Start").Return(errors.New("FAILED"))
+
+	suite.hassMock.On("UpdateState", suite.State("running")).Return(nil).Once()
+	suite.hassMock.On("UpdateState", suite.State("failure")).Return(nil).Once()
+
+	// the final state is spooled before being delivered
+	spoolMock.On("Store", suite.State("failure")).Return(nil).Once()
+	spoolMock.On("Clear").Return(nil).Once()
+
+	suite.runner.Run()
+	suite.runner.Linger()
+
+	suite.hassMock.AssertExpectations(suite.T())
+	spoolMock.AssertExpectations(suite.T())
+}
+
+func (suite *RunnerTestSuite) TestSupersededFinalStateIsNotDelivered() {
+	monkey.UnpatchAll()
+	lingerRetryInterval = 0
+
+	spoolMock := &SpoolMock{}
+	suite.runner = suite.newRunner(WithSpool(spoolMock, time.Hour))
+
+	stdoutReader, _ := io.Pipe()
+	suite.cmdMock.On("StdoutPipe").Return(stdoutReader, nil)
+
+	stderrReader, _ := io.Pipe()
+	suite.cmdMock.On("StderrPipe").Return(stderrReader, nil)
+
+	suite.cmdMock.On("Start").Return(errors.New("FAILED"))
+
+	suite.hassMock.On("UpdateState", suite.State("running")).Return(nil).Once()
+	suite.hassMock.On("UpdateState", suite.State("failure")).Return(errors.New("OFFLINE")).Once()
+
+	spoolMock.On("Store", suite.State("failure")).Return(nil).Once()
+	// a newer run cleared the spooled state
+	spoolMock.On("Load").Return("", nil).Once()
+
+	suite.runner.Run()
+	suite.runner.Linger()
+
+	suite.hassMock.AssertExpectations(suite.T())
+	spoolMock.AssertExpectations(suite.T())
+}
+
+func (suite *RunnerTestSuite) TestTimeout() {
+	monkey.UnpatchAll()
+
+	suite.runner = suite.newRunner(
+		WithTimeout(10*time.Millisecond),
+		WithTermination(TerminationPolicy{
+			Signal:      syscall.SIGINT,
+			GracePeriod: time.Hour,
+		}),
+	)
+
+	waitChan := suite.mockBlockingCommand()
+
+	suite.cmdMock.On("Signal", syscall.SIGINT).Return(nil).Once().Run(func(args mock.Arguments) {
+		close(waitChan)
+	})
+
+	suite.hassMock.On("UpdateState", suite.State("running")).Return(nil).Once()
+	suite.hassMock.On("UpdateState", suite.State("timeout")).Return(nil).Once()
+
+	suite.runner.Run()
+
+	suite.hassMock.AssertExpectations(suite.T())
+	suite.cmdMock.AssertExpectations(suite.T())
+}
+
+func (suite *RunnerTestSuite) TestKilledAfterGracePeriod() {
+	monkey.UnpatchAll()
+
+	suite.runner = suite.newRunner(
+		WithTimeout(10*time.Millisecond),
+		WithTermination(TerminationPolicy{
+			Signal:      syscall.SIGTERM,
+			GracePeriod: 10 * time.Millisecond,
+		}),
+	)
+
+	waitChan := suite.mockBlockingCommand()
+
+	suite.cmdMock.On("Signal", syscall.SIGTERM).Return(nil).Once()
+	suite.cmdMock.On("Kill").Return(nil).Once().Run(func(args mock.Arguments) {
+		close(waitChan)
+	})
+
+	suite.hassMock.On("UpdateState", suite.State("running")).Return(nil).Once()
+	suite.hassMock.On("UpdateState", suite.State("timeout")).Return(nil).Once()
+
+	suite.runner.Run()
+
+	suite.hassMock.AssertExpectations(suite.T())
+	suite.cmdMock.AssertExpectations(suite.T())
+}
+
+func (suite *RunnerTestSuite) TestCancelledByUser() {
+	monkey.UnpatchAll()
+
+	waitChan := suite.mockBlockingCommand()
+
+	suite.cmdMock.On("Signal", syscall.SIGTERM).Return(nil).Once().Run(func(args mock.Arguments) {
+		close(waitChan)
+	})
+
+	suite.hassMock.On("UpdateState", suite.State("running")).Return(nil).Once().Run(func(args mock.Arguments) {
+		go syscall.Kill(os.Getpid(), CancelSignal)
+	})
+	suite.hassMock.On("UpdateState", mock.MatchedBy(func(json string) bool {
+		return strings.Contains(json, `"state":"cancelled"`) &&
+			strings.Contains(json, `"cancelled_by":"user"`)
+	})).Return(nil).Once()
+
+	suite.runner.Run()
+
+	suite.hassMock.AssertExpectations(suite.T())
+	suite.cmdMock.AssertExpectations(suite.T())
+}
+
+func (suite *RunnerTestSuite) TestRejectedRuns() {
+	monkey.UnpatchAll()
+
+	waitChan := suite.mockBlockingCommand()
+
+	var once sync.Once
+
+	suite.hassMock.On("UpdateState", suite.State("running")).Return(nil).Run(func(args mock.Arguments) {
+		if strings.Contains(args.String(0), `"rejected":0`) {
+			once.Do(func() { go syscall.Kill(os.Getpid(), RejectSignal) })
+		} else if strings.Contains(args.String(0), `"rejected":1`) {
+			close(waitChan)
+		}
+	})
+	suite.hassMock.On("UpdateState", mock.MatchedBy(func(json string) bool {
+		return strings.Contains(json, `"state":"failure"`) &&
+			strings.Contains(json, `"rejected":1`)
+	})).Return(nil).Once()
+
+	suite.runner.Run()
+
+	suite.hassMock.AssertExpectations(suite.T())
+}
+
+func (suite *RunnerTestSuite) TestOnStart() {
+	monkey.UnpatchAll()
+
+	var started []error
+
+	suite.runner = suite.newRunner(WithOnStart(func(err error) {
+		started = append(started, err)
+	}))
+
+	stdoutReader, stdoutWriter := io.Pipe()
+	stdoutWriter.Close()
+	suite.cmdMock.On("StdoutPipe").Return(stdoutReader, nil)
+
+	stderrReader, stderrWriter := io.Pipe()
+	stderrWriter.Close()
+	suite.cmdMock.On("StderrPipe").Return(stderrReader, nil)
+
+	suite.cmdMock.On("Start").Return(errors.New("no such file or directory"))
+
+	suite.hassMock.On("UpdateState", suite.State("running")).Return(nil)
+	suite.hassMock.On("UpdateState", mock.MatchedBy(func(json string) bool {
+		return strings.Contains(json, `"state":"failure"`) &&
+			strings.Contains(json, `"output":"no such file or directory\n"`)
+	})).Return(nil).Once()
+
+	suite.runner.Run()
+
+	suite.Len(started, 1)
+	suite.EqualError(started[0], "no such file or directory")
+	suite.hassMock.AssertExpectations(suite.T())
+}
+
+func (suite *RunnerTestSuite) TestEvents() {
+	monkey.UnpatchAll()
+
+	var fired []string
+	var ended map[string]interface{}
+
+	eventBus := &EventBusMock{}
+	eventBus.On("FireEvent", mock.Anything, mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		data := args.Get(1).(map[string]interface{})
+		suite.Equal("shell.test", data["entity_id"])
+
+		fired = append(fired, args.String(0))
+
+		if args.String(0) == "hass_run_output" {
+			suite.Equal(StreamStdout, data["stream"])
+			suite.Contains([]string{"a", "b", "c"}, data["line"])
+		}
+
+		if args.String(0) == "hass_run_failed" {
+			ended = data
+		}
+	})
+
+	suite.runner = suite.newRunner(WithEvents(
+		eventBus,
+		[]string{EventStarted, EventOutput, EventFinished, EventFailed},
+		2,
+	))
+
+	stdoutReader, stdoutWriter := io.Pipe()
+	suite.cmdMock.On("StdoutPipe").Return(stdoutReader, nil)
+
+	stderrReader, stderrWriter := io.Pipe()
+	stderrWriter.Close()
+	suite.cmdMock.On("StderrPipe").Return(stderrReader, nil)
+
+	suite.cmdMock.On("Start").Return(nil).Run(func(args mock.Arguments) {
+		go func() {
+			stdoutWriter.Write([]byte("a\nb\nc\n"))
+			stdoutWriter.Close()
+		}()
+	})
+	suite.cmdMock.On("Wait").Return(errors.New("FAILED"))
+
+	suite.hassMock.On("UpdateState", mock.Anything).Return(nil)
+
+	suite.runner.Run()
+
+	suite.Equal([]string{
+		"hass_run_started",
+		"hass_run_output",
+		"hass_run_output",
+		"hass_run_output",
+		"hass_run_finished",
+		"hass_run_failed",
+	}, fired)
+
+	suite.Equal(StateFailure, ended["state"])
+	suite.Equal(CommandFailedExitCode, ended["exit_code"])
+	suite.Equal("c\nFAILED\n", ended["output"])
+}
+
+func (suite *RunnerTestSuite) TestHooks() {
+	monkey.UnpatchAll()
+
+	onFailure, err := NewHook(
+		HookOnFailure,
+		"notify.mobile_app_phone",
+		map[string]interface{}{
+			"title":   "{{.Entity}} {{.State}}",
+			"message": "exit code {{.ExitCode}}: {{.Output}}",
+			"data":    map[string]interface{}{"priority": "high", "ttl": 0},
+		},
+		1,
+	)
+	suite.Nil(err)
+
+	onSuccess, err := NewHook(HookOnSuccess, "persistent_notification.create", nil, 1)
+	suite.Nil(err)
+
+	serviceCaller := &ServiceCallerMock{}
+	serviceCaller.On("CallService", "notify", "mobile_app_phone", map[string]interface{}{
+		"title":   "shell.test failure",
+		"message": "exit code -10: FAILED\n",
+		"data":    map[string]interface{}{"priority": "high", "ttl": 0},
+	}).Return(nil).Once()
+
+	suite.runner = suite.newRunner(WithHooks(serviceCaller, onFailure, onSuccess))
+
+	stdoutReader, stdoutWriter := io.Pipe()
+	suite.cmdMock.On("StdoutPipe").Return(stdoutReader, nil)
+
+	stderrReader, stderrWriter := io.Pipe()
+	stderrWriter.Close()
+	suite.cmdMock.On("StderrPipe").Return(stderrReader, nil)
+
+	suite.cmdMock.On("Start").Return(nil).Run(func(args mock.Arguments) {
+		go func() {
+			stdoutWriter.Write([]byte("a\n"))
+			stdoutWriter.Close()
+		}()
+	})
+	suite.cmdMock.On("Wait").Return(errors.New("FAILED"))
+
+	suite.hassMock.On("UpdateState", mock.Anything).Return(nil)
+
+	suite.runner.Run()
+
+	serviceCaller.AssertExpectations(suite.T())
+}
+
+func (suite *RunnerTestSuite) TestSeparateStreamsAndStructuredOutput() {
+	monkey.UnpatchAll()
+
+	suite.runner = suite.newRunner(WithSeparateStreams(), WithStructuredOutput())
+
+	stdoutReader, stdoutWriter := io.Pipe()
+	suite.cmdMock.On("StdoutPipe").Return(stdoutReader, nil)
+
+	stderrReader, stderrWriter := io.Pipe()
+	suite.cmdMock.On("StderrPipe").Return(stderrReader, nil)
+
+	suite.cmdMock.On("Start").Return(nil).Run(func(args mock.Arguments) {
+		go func() {
+			stdoutWriter.Write([]byte("out\n"))
+			stdoutWriter.Close()
+		}()
+		go func() {
+			stderrWriter.Write([]byte("err\n"))
+			stderrWriter.Close()
+		}()
+	})
+
+	// the command ends once both lines were published
+	waitChan := make(chan time.Time)
+	suite.cmdMock.On("Wait").Return(nil).WaitFor = waitChan
+
+	var once sync.Once
+
+	suite.hassMock.On("UpdateState", suite.State("running")).Return(nil).Run(func(args mock.Arguments) {
+		if strings.Contains(args.String(0), `"stdout":"out\n","stderr":"err\n"`) {
+			once.Do(func() { close(waitChan) })
+		}
+	})
+	suite.hassMock.On("UpdateState", mock.MatchedBy(func(state string) bool {
+		var payload Payload
+		suite.Nil(json.Unmarshal([]byte(state), &payload))
+
+		if payload.State != StateSuccess {
+			return false
+		}
+
+		suite.Equal("out\n", payload.Attributes.Stdout)
+		suite.Equal("err\n", payload.Attributes.Stderr)
+		suite.Equal("err", payload.Attributes.LastErrorLine)
+		suite.Len(payload.Attributes.Lines, 2)
+
+		for _, line := range payload.Attributes.Lines {
+			suite.Equal(line.Stream, map[string]string{"out": StreamStdout, "err": StreamStderr}[line.Text])
+			suite.False(line.Date.IsZero())
+		}
+
+		return true
+	})).Return(nil).Once()
+
+	suite.runner.Run()
+
+	suite.hassMock.AssertExpectations(suite.T())
+}
+
+// mockBlockingCommand mocks a command without output whose Wait blocks until
+// the returned channel is closed.
+func (suite *RunnerTestSuite) TestProgress() {
+	monkey.UnpatchAll()
+
+	parser, err := NewProgressParser("rsync")
+	suite.Nil(err)
+
+	suite.runner = suite.newRunner(WithProgress(parser))
+
+	stdoutReader, stdoutWriter := io.Pipe()
+	suite.cmdMock.On("StdoutPipe").Return(stdoutReader, nil)
+
+	stderrReader, stderrWriter := io.Pipe()
+	suite.cmdMock.On("StderrPipe").Return(stderrReader, nil)
+
+	suite.cmdMock.On("Start").Return(nil).Run(func(args mock.Arguments) {
+		go func() {
+			stdoutWriter.Write([]byte("  1,024  10%  1.00MB/s    0:00:09\r  5,120  50%  1.00MB/s    0:00:05\rdone\n"))
+			stdoutWriter.Close()
+		}()
+		stderrWriter.Close()
+	})
+	suite.cmdMock.On("Wait").Return(nil)
+
+	suite.hassMock.On("UpdateState", suite.State("running")).Return(nil)
+	suite.hassMock.On("UpdateState", mock.MatchedBy(func(state string) bool {
+		var payload Payload
+		suite.Nil(json.Unmarshal([]byte(state), &payload))
+
+		if payload.State != StateSuccess {
+			return false
+		}
+
+		suite.Equal("done\n", payload.Attributes.Output)
+		suite.Equal(50.0, *payload.Attributes.Progress)
+		suite.Equal("0:00:05", payload.Attributes.ETA)
+
+		return true
+	})).Return(nil).Once()
+
+	suite.runner.Run()
+
+	suite.hassMock.AssertExpectations(suite.T())
+}
+
+func (suite *RunnerTestSuite) TestControlLines() {
+	monkey.UnpatchAll()
+
+	stdoutReader, stdoutWriter := io.Pipe()
+	suite.cmdMock.On("StdoutPipe").Return(stdoutReader, nil)
+
+	stderrReader, stderrWriter := io.Pipe()
+	suite.cmdMock.On("StderrPipe").Return(stderrReader, nil)
+
+	suite.cmdMock.On("Start").Return(nil).Run(func(args mock.Arguments) {
+		go func() {
+			stdoutWriter.Write([]byte(
+				"::hass-run::{\"phase\": \"scan\", \"files_copied\": 0}\n" +
+					"copying\n" +
+					"::hass-run::{\"phase\": null, \"files_copied\": 1234}\n" +
+					"::hass-run::{invalid\n",
+			))
+			stdoutWriter.Close()
+		}()
+		go func() {
+			stderrWriter.Write([]byte("::hass-run::{\"stream\": \"stderr\"}\n"))
+			stderrWriter.Close()
+		}()
+	})
+	suite.cmdMock.On("Wait").Return(nil)
+
+	suite.hassMock.On("UpdateState", suite.State("running")).Return(nil)
+	suite.hassMock.On("UpdateState", mock.MatchedBy(func(state string) bool {
+		var payload Payload
+		suite.Nil(json.Unmarshal([]byte(state), &payload))
+
+		if payload.State != StateSuccess {
+			return false
+		}
+
+		suite.Equal(map[string]interface{}{"files_copied": 1234.0}, payload.Attributes.Extra)
+		suite.Contains(payload.Attributes.Output, "copying\n")
+		suite.Contains(payload.Attributes.Output, "::hass-run::{\"stream\": \"stderr\"}\n")
+		suite.NotContains(payload.Attributes.Output, "phase")
+
+		return true
+	})).Return(nil).Once()
+
+	suite.runner.Run()
+
+	suite.hassMock.AssertExpectations(suite.T())
+}
+
+func (suite *RunnerTestSuite) mockBlockingCommand() chan time.Time {
+	stdoutReader, stdoutWriter := io.Pipe()
+	stdoutWriter.Close()
+	suite.cmdMock.On("StdoutPipe").Return(stdoutReader, nil)
+
+	stderrReader, stderrWriter := io.Pipe()
+	stderrWriter.Close()
+	suite.cmdMock.On("StderrPipe").Return(stderrReader, nil)
+
+	suite.cmdMock.On("Start").Return(nil)
+
+	waitChan := make(chan time.Time)
+	suite.cmdMock.On("Wait").Return(errors.New("signal: terminated")).WaitFor = waitChan
+
+	return waitChan
+}
+
+func (suite *RunnerTestSuite) State(state string) interface{} {
+	return mock.MatchedBy(func(json string) bool {
+		return strings.Contains(json, `"state":"`+state+`"`)
+	})
+}
+
 func (suite *RunnerTestSuite) Payload(payload Payload) string {
 	bytes, err := json.Marshal(payload)
 	suite.Nil(err)
@@ -0,0 +1,95 @@
+package runner
+
+import (
+	"strings"
+	"time"
+)
+
+// runState is the mutable state of a run. It is shared by the goroutines
+// reading the command output, the one terminating the command and the one
+// waiting for it, and is only accessed through Runner.update and
+// Runner.snapshot.
+type runState struct {
+	output        *OutputBuffer
+	stdout        *OutputBuffer
+	stderr        *OutputBuffer
+	lastErrorLine string
+	progress      *float64
+	eta           string
+	extra         map[string]interface{}
+	running       bool
+	exitCode      int
+	signal        string
+	coreDumped    bool
+	cancelledBy   string
+	rejected      int
+	startedAt     time.Time
+	updatedAt     time.Time
+	endedAt       time.Time
+}
+
+func newRunState(policy OutputPolicy) runState {
+	return runState{
+		output: NewOutputBuffer(policy),
+		stdout: NewOutputBuffer(policy),
+		stderr: NewOutputBuffer(policy),
+	}
+}
+
+func (s *runState) appendLine(line OutputLine) {
+	s.output.AppendLine(line)
+
+	if line.Stream == StreamStderr {
+		s.stderr.AppendLine(line)
+
+		if text := strings.TrimSpace(line.Text); text != "" {
+			s.lastErrorLine = strings.TrimSuffix(line.Text, "\n")
+		}
+	} else {
+		s.stdout.AppendLine(line)
+	}
+
+	s.updatedAt = line.Date
+}
+
+func (s *runState) setProgress(progress float64, eta string) {
+	s.progress = &progress
+	s.eta = eta
+	s.updatedAt = time.Now()
+}
+
+func (s *runState) setExtra(attributes map[string]interface{}) {
+	if s.extra == nil {
+		s.extra = map[string]interface{}{}
+	}
+
+	for key, value := range attributes {
+		if value == nil {
+			delete(s.extra, key)
+		} else {
+			s.extra[key] = value
+		}
+	}
+
+	s.updatedAt = time.Now()
+}
+
+func (s *runState) appendOutput(content string) {
+	s.output.Append(content)
+	s.updatedAt = time.Now()
+}
+
+func (s *runState) name() string {
+	switch {
+	case s.running:
+		return StateRunning
+	case s.cancelledBy == CancelledByTimeout:
+		return StateTimeout
+	case s.cancelledBy != "":
+		return StateCancelled
+	case s.exitCode == 0:
+		return StateSuccess
+	}
+
+	return StateFailure
+}
@@ -0,0 +1,52 @@
+package runner
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+)
+
+// StateFile keeps the last state of a run on disk so that it can be
+// inspected locally without querying Home-Assistant.
+type StateFile struct {
+	path string
+}
+
+func NewStateFile(path string) *StateFile {
+	return &StateFile{
+		path: path,
+	}
+}
+
+func (s *StateFile) UpdateState(json string) error {
+	tmp := s.path + ".tmp"
+
+	if err := ioutil.WriteFile(tmp, []byte(json), 0644); err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+
+	if err := os.Rename(tmp, s.path); err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+
+	return nil
+}
+
+func (s *StateFile) Load() (Payload, error) {
+	var payload Payload
+
+	content, err := ioutil.ReadFile(s.path)
+
+	if err != nil {
+		return payload, fmt.Errorf("failed to read state file: %w", err)
+	}
+
+	err = json.Unmarshal(content, &payload)
+
+	if err != nil {
+		return payload, fmt.Errorf("failed to parse state file: %w", err)
+	}
+
+	return payload, nil
+}
@@ -0,0 +1,47 @@
+package runner
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type StateFileTestSuite struct {
+	suite.Suite
+}
+
+func (suite *StateFileTestSuite) TestUpdateAndLoad() {
+	stateFile := NewStateFile(filepath.Join(suite.T().TempDir(), "cmd.pid.state"))
+
+	payload := Payload{
+		State: StateSuccess,
+		Attributes: Attributes{
+			Output:    "Hello world\n",
+			StartedAt: time.Date(2022, 4, 29, 8, 24, 51, 0, time.UTC),
+			Duration:  10,
+		},
+	}
+
+	bytes, err := json.Marshal(payload)
+	suite.Nil(err)
+
+	suite.Nil(stateFile.UpdateState(string(bytes)))
+
+	loaded, err := stateFile.Load()
+	suite.Nil(err)
+	suite.Equal(payload, loaded)
+}
+
+func (suite *StateFileTestSuite) TestMissingFile() {
+	_, err := NewStateFile(filepath.Join(suite.T().TempDir(), "missing")).Load()
+	suite.True(errors.Is(err, os.ErrNotExist))
+}
+
+func TestStateFileTestSuite(t *testing.T) {
+	suite.Run(t, new(StateFileTestSuite))
+}
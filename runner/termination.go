@@ -0,0 +1,45 @@
+package runner
+
+import (
+	"fmt"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+const DefaultGracePeriod = 10 * time.Second
+
+// TerminationPolicy describes how a command is stopped: Signal is sent first
+// and the command is killed if it is still running after GracePeriod.
+type TerminationPolicy struct {
+	Signal      syscall.Signal
+	GracePeriod time.Duration
+}
+
+func ParseSignal(name string) (syscall.Signal, error) {
+	name = strings.ToUpper(name)
+
+	if !strings.HasPrefix(name, "SIG") {
+		name = "SIG" + name
+	}
+
+	signal := unix.SignalNum(name)
+
+	if signal == 0 {
+		return 0, fmt.Errorf("unknown signal %q", name)
+	}
+
+	return signal, nil
+}
+
+func SignalName(signal syscall.Signal) string {
+	name := unix.SignalName(signal)
+
+	if name == "" {
+		return fmt.Sprintf("signal %d", signal)
+	}
+
+	return name
+}